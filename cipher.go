@@ -0,0 +1,358 @@
+package skynet
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+type (
+	// EncryptionKey is a key used to encrypt uploads on the client with
+	// XChaCha20-Poly1305, so that neither the portal nor anyone else with
+	// the skylink can read them. The contents and the filenames are
+	// encrypted with separate subkeys derived from the key.
+	EncryptionKey [chacha20poly1305.KeySize]byte
+
+	// encryptReader encrypts the data read from a reader in the chunked
+	// format of client-side encryption.
+	encryptReader struct {
+		src         io.Reader
+		aead        cipher.AEAD
+		noncePrefix [encryptionNoncePrefixSize]byte
+		chunk       uint64
+		done        bool
+
+		// buf is the ciphertext that wasn't read yet, plaintext and
+		// ciphertext are the buffers for the chunk that is encrypted next.
+		buf        []byte
+		plaintext  []byte
+		ciphertext []byte
+
+		// remaining is the number of bytes left to read, or -1 if the size
+		// of the source is unknown.
+		remaining int64
+	}
+
+	// decryptReader decrypts data encrypted by an encryptReader.
+	decryptReader struct {
+		src         io.ReadCloser
+		aead        cipher.AEAD
+		noncePrefix [encryptionNoncePrefixSize]byte
+		chunk       uint64
+		headerRead  bool
+		done        bool
+		err         error
+
+		// buf is the plaintext that wasn't read yet and ciphertext is the
+		// buffer for the chunk that is decrypted next.
+		buf        []byte
+		ciphertext []byte
+	}
+)
+
+const (
+	// encryptionMagic and encryptionVersion start the header of encrypted
+	// data, followed by the nonce prefix.
+	encryptionMagic   = "skyenc"
+	encryptionVersion = 1
+
+	// encryptionChunkSize is the size of the plaintext of every chunk but
+	// the last one, which is shorter and possibly empty.
+	encryptionChunkSize = 1 << 16
+
+	// encryptionNoncePrefixSize is the size of the random prefix of the
+	// nonces of the chunks of a file, which end with the index of the chunk.
+	encryptionNoncePrefixSize = chacha20poly1305.NonceSizeX - 8
+
+	// encryptionTagSize is the size of the authentication tag of every chunk.
+	encryptionTagSize = 16
+
+	// encryptionHeaderSize is the size of the header of encrypted data.
+	encryptionHeaderSize = len(encryptionMagic) + 1 + encryptionNoncePrefixSize
+
+	// contentKeyLabel, filenameKeyLabel and filenameNonceKeyLabel are the
+	// labels of the subkeys derived from an encryption key, so that the key
+	// is never used for more than one purpose.
+	contentKeyLabel       = "skyenc-content-aead"
+	filenameKeyLabel      = "skyenc-filename-aead"
+	filenameNonceKeyLabel = "skyenc-filename-nonce"
+)
+
+var (
+	// ErrDecryption is returned when encrypted data could not be decrypted
+	// because it was modified, truncated or encrypted with a different key.
+	ErrDecryption = errors.New("could not decrypt data")
+
+	// finalChunkData and chunkData are the additional data of the last and
+	// the other chunks, which prevents truncating encrypted data at a chunk
+	// boundary.
+	finalChunkData = []byte{1}
+	chunkData      = []byte{0}
+)
+
+// GenEncryptionKey generates a random key for client-side encryption. The key
+// has to be stored to download the encrypted uploads.
+func GenEncryptionKey() (EncryptionKey, error) {
+	var key EncryptionKey
+	_, err := rand.Read(key[:])
+	if err != nil {
+		return EncryptionKey{}, errors.AddContext(err, "could not generate key")
+	}
+	return key, nil
+}
+
+// EncryptFilename encrypts the filename with the key, like uploads with an
+// encryption key do. Every element of a path is encrypted separately, so the
+// directory structure is kept. The same filename always results in the same
+// encrypted filename, which allows downloading files from encrypted
+// directories by path.
+func EncryptFilename(key EncryptionKey, filename string) (string, error) {
+	aead, err := newCipher(key, filenameKeyLabel)
+	if err != nil {
+		return "", err
+	}
+	nonceKey := key.subkey(filenameNonceKeyLabel)
+	elements := strings.Split(filename, "/")
+	for i, element := range elements {
+		if element == "" {
+			continue
+		}
+		// The nonce is derived from the key and the element, which leaks
+		// equal filenames but nothing else.
+		mac, err := blake2b.New(chacha20poly1305.NonceSizeX, nonceKey)
+		if err != nil {
+			return "", errors.AddContext(err, "could not create MAC")
+		}
+		_, _ = mac.Write([]byte(element))
+		nonce := mac.Sum(nil)
+		encrypted := aead.Seal(nonce, nonce, []byte(element), nil)
+		elements[i] = base64.RawURLEncoding.EncodeToString(encrypted)
+	}
+	return strings.Join(elements, "/"), nil
+}
+
+// DecryptFilename decrypts a filename encrypted with EncryptFilename.
+func DecryptFilename(key EncryptionKey, filename string) (string, error) {
+	aead, err := newCipher(key, filenameKeyLabel)
+	if err != nil {
+		return "", err
+	}
+	elements := strings.Split(filename, "/")
+	for i, element := range elements {
+		if element == "" {
+			continue
+		}
+		encrypted, err := base64.RawURLEncoding.DecodeString(element)
+		if err != nil {
+			return "", errors.Compose(ErrDecryption, errors.AddContext(err, "could not decode filename"))
+		}
+		if len(encrypted) < aead.NonceSize()+aead.Overhead() {
+			return "", errors.AddContext(ErrDecryption, "encrypted filename is too short")
+		}
+		nonce, ciphertext := encrypted[:aead.NonceSize()], encrypted[aead.NonceSize():]
+		decrypted, err := aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			return "", errors.Compose(ErrDecryption, err)
+		}
+		elements[i] = string(decrypted)
+	}
+	return strings.Join(elements, "/"), nil
+}
+
+// subkey derives the subkey with the given label from the key.
+func (key EncryptionKey) subkey(label string) []byte {
+	// The key is at most 64 bytes long, so creating the MAC can't fail.
+	mac, _ := blake2b.New256(key[:])
+	_, _ = mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// newCipher returns an XChaCha20-Poly1305 cipher keyed with the subkey with
+// the given label.
+func newCipher(key EncryptionKey, label string) (cipher.AEAD, error) {
+	aead, err := chacha20poly1305.NewX(key.subkey(label))
+	if err != nil {
+		return nil, errors.AddContext(err, "could not create cipher")
+	}
+	return aead, nil
+}
+
+// encryptUploadData returns the upload data with encrypted filenames, whose
+// contents are encrypted as they are read.
+func encryptUploadData(key EncryptionKey, uploadData UploadData) (UploadData, error) {
+	encrypted := make(UploadData, len(uploadData))
+	for filename, data := range uploadData {
+		encryptedFilename, err := EncryptFilename(key, filename)
+		if err != nil {
+			return nil, errors.AddContext(err, fmt.Sprintf("could not encrypt filename %v", filename))
+		}
+		er, err := newEncryptReader(key, data)
+		if err != nil {
+			return nil, errors.AddContext(err, fmt.Sprintf("could not encrypt file %v", filename))
+		}
+		encrypted[encryptedFilename] = er
+	}
+	return encrypted, nil
+}
+
+// encryptedSize returns the size of the encrypted data for plaintext of the
+// given size.
+func encryptedSize(size int64) int64 {
+	numChunks := size/encryptionChunkSize + 1
+	return int64(encryptionHeaderSize) + size + numChunks*encryptionTagSize
+}
+
+// chunkNonce returns the nonce of the chunk with the given index.
+func chunkNonce(noncePrefix [encryptionNoncePrefixSize]byte, chunk uint64) []byte {
+	nonce := make([]byte, 0, chacha20poly1305.NonceSizeX)
+	nonce = append(nonce, noncePrefix[:]...)
+	return appendUint64(nonce, chunk)
+}
+
+// newEncryptReader returns a reader that encrypts the data read from src.
+func newEncryptReader(key EncryptionKey, src io.Reader) (*encryptReader, error) {
+	aead, err := newCipher(key, contentKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	er := &encryptReader{
+		src:        src,
+		aead:       aead,
+		plaintext:  make([]byte, encryptionChunkSize),
+		ciphertext: make([]byte, 0, encryptionChunkSize+encryptionTagSize),
+		remaining:  -1,
+	}
+	_, err = rand.Read(er.noncePrefix[:])
+	if err != nil {
+		return nil, errors.AddContext(err, "could not generate nonce")
+	}
+	if size := readerSize(src); size >= 0 {
+		er.remaining = encryptedSize(size)
+	}
+	er.buf = append([]byte(encryptionMagic), encryptionVersion)
+	er.buf = append(er.buf, er.noncePrefix[:]...)
+	return er, nil
+}
+
+// Len returns the number of bytes left to read, or -1 if it is unknown. It
+// allows reporting the progress of encrypted uploads.
+func (er *encryptReader) Len() int {
+	return int(er.remaining)
+}
+
+// Read implements io.Reader.
+func (er *encryptReader) Read(p []byte) (int, error) {
+	for len(er.buf) == 0 {
+		if er.done {
+			return 0, io.EOF
+		}
+		if err := er.sealChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, er.buf)
+	er.buf = er.buf[n:]
+	if er.remaining >= 0 {
+		er.remaining -= int64(n)
+	}
+	return n, nil
+}
+
+// sealChunk reads and encrypts the next chunk.
+func (er *encryptReader) sealChunk() error {
+	n, err := io.ReadFull(er.src, er.plaintext)
+	final := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !final {
+		return err
+	}
+	additionalData := chunkData
+	if final {
+		additionalData = finalChunkData
+	}
+	er.buf = er.aead.Seal(er.ciphertext[:0], chunkNonce(er.noncePrefix, er.chunk), er.plaintext[:n], additionalData)
+	er.chunk++
+	er.done = final
+	return nil
+}
+
+// newDecryptReader returns a reader that decrypts the data read from src.
+func newDecryptReader(key EncryptionKey, src io.ReadCloser) (*decryptReader, error) {
+	aead, err := newCipher(key, contentKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		src:        src,
+		aead:       aead,
+		ciphertext: make([]byte, encryptionChunkSize+encryptionTagSize),
+	}, nil
+}
+
+// Close implements io.Closer.
+func (dr *decryptReader) Close() error {
+	return dr.src.Close()
+}
+
+// Read implements io.Reader.
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+		if dr.done {
+			return 0, io.EOF
+		}
+		dr.err = dr.openChunk()
+	}
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+// openChunk reads and decrypts the next chunk, reading the header first if
+// necessary.
+func (dr *decryptReader) openChunk() error {
+	if !dr.headerRead {
+		header := make([]byte, encryptionHeaderSize)
+		_, err := io.ReadFull(dr.src, header)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errors.AddContext(ErrDecryption, "data is too short")
+		}
+		if err != nil {
+			return err
+		}
+		prefix := append([]byte(encryptionMagic), encryptionVersion)
+		if !bytes.HasPrefix(header, prefix) {
+			return errors.AddContext(ErrDecryption, "data isn't encrypted with a supported format")
+		}
+		copy(dr.noncePrefix[:], header[len(prefix):])
+		dr.headerRead = true
+	}
+
+	// Only the last chunk is shorter than a full chunk.
+	n, err := io.ReadFull(dr.src, dr.ciphertext)
+	final := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !final {
+		return err
+	}
+	additionalData := chunkData
+	if final {
+		additionalData = finalChunkData
+	}
+	plaintext, err := dr.aead.Open(dr.ciphertext[:0], chunkNonce(dr.noncePrefix, dr.chunk), dr.ciphertext[:n], additionalData)
+	if err != nil {
+		return errors.Compose(ErrDecryption, errors.AddContext(err, fmt.Sprintf("could not decrypt chunk %v", dr.chunk)))
+	}
+	dr.buf = plaintext
+	dr.chunk++
+	dr.done = final
+	return nil
+}
@@ -0,0 +1,208 @@
+package skynet
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// encryptData encrypts the data in the format of client-side encryption.
+func encryptData(t *testing.T, key EncryptionKey, data []byte) []byte {
+	er, err := newEncryptReader(key, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if er.Len() != int(encryptedSize(int64(len(data)))) {
+		t.Fatalf("expected size %v, got %v", encryptedSize(int64(len(data))), er.Len())
+	}
+	encrypted, err := ioutil.ReadAll(er)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(encrypted)) != encryptedSize(int64(len(data))) {
+		t.Fatalf("expected %v encrypted bytes, got %v", encryptedSize(int64(len(data))), len(encrypted))
+	}
+	return encrypted
+}
+
+// decryptData decrypts data encrypted with encryptData.
+func decryptData(key EncryptionKey, encrypted []byte) ([]byte, error) {
+	dr, err := newDecryptReader(key, ioutil.NopCloser(bytes.NewReader(encrypted)))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(dr)
+}
+
+// TestEncryptReader tests encrypting and decrypting data of sizes around the
+// chunk size.
+func TestEncryptReader(t *testing.T) {
+	key, err := GenEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := []int{0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 3*encryptionChunkSize + 5}
+	for _, size := range sizes {
+		data := make([]byte, size)
+		_, _ = rand.Read(data)
+		encrypted := encryptData(t, key, data)
+		decrypted, err := decryptData(key, encrypted)
+		if err != nil {
+			t.Fatalf("%v: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("%v: decrypted data doesn't match", size)
+		}
+	}
+}
+
+// TestDecryptReaderInvalid tests that modified, truncated and differently
+// encrypted data doesn't decrypt.
+func TestDecryptReaderInvalid(t *testing.T) {
+	key, err := GenEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := GenEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 2*encryptionChunkSize)
+	encrypted := encryptData(t, key, data)
+	chunkEnd := encryptionHeaderSize + encryptionChunkSize + encryptionTagSize
+
+	modified := append([]byte(nil), encrypted...)
+	modified[encryptionHeaderSize] ^= 1
+	tests := []struct {
+		name      string
+		key       EncryptionKey
+		encrypted []byte
+	}{
+		{"modified", key, modified},
+		{"truncated chunk", key, encrypted[:len(encrypted)-1]},
+		{"truncated at chunk boundary", key, encrypted[:chunkEnd]},
+		{"missing final chunk", key, encrypted[:2*chunkEnd-encryptionHeaderSize]},
+		{"header only", key, encrypted[:encryptionHeaderSize]},
+		{"empty", key, nil},
+		{"reordered", key, append(append(append([]byte(nil), encrypted[:encryptionHeaderSize]...), encrypted[chunkEnd:2*chunkEnd-encryptionHeaderSize]...), encrypted[encryptionHeaderSize:chunkEnd]...)},
+		{"wrong key", otherKey, encrypted},
+	}
+	for _, test := range tests {
+		_, err := decryptData(test.key, test.encrypted)
+		if !errors.Contains(err, ErrDecryption) {
+			t.Fatalf("%v: expected error %v, got %v", test.name, ErrDecryption, err)
+		}
+	}
+}
+
+// TestEncryptFilename tests encrypting and decrypting filenames.
+func TestEncryptFilename(t *testing.T) {
+	key, err := GenEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	filenames := []string{"", "file.txt", "dir/file.txt", "/dir/subdir/file.txt"}
+	for _, filename := range filenames {
+		encrypted, err := EncryptFilename(key, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filename != "" && encrypted == filename {
+			t.Fatalf("%q: filename wasn't encrypted", filename)
+		}
+		// The encryption is deterministic so files can be found by path.
+		encrypted2, err := EncryptFilename(key, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encrypted2 != encrypted {
+			t.Fatalf("%q: expected %v, got %v", filename, encrypted, encrypted2)
+		}
+		decrypted, err := DecryptFilename(key, encrypted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted != filename {
+			t.Fatalf("expected %q, got %q", filename, decrypted)
+		}
+	}
+
+	otherKey, err := GenEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := EncryptFilename(key, "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DecryptFilename(otherKey, encrypted)
+	if !errors.Contains(err, ErrDecryption) {
+		t.Fatalf("expected error %v, got %v", ErrDecryption, err)
+	}
+}
+
+// TestEncryptionSubkeys tests that contents and filenames are encrypted with
+// subkeys derived from the key and never with the key itself.
+func TestEncryptionSubkeys(t *testing.T) {
+	var key EncryptionKey
+	for i := range key {
+		key[i] = byte(i)
+	}
+	subkey := func(label string) []byte {
+		mac, err := blake2b.New256(key[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+	newAEAD := func(k []byte) cipher.AEAD {
+		aead, err := chacha20poly1305.NewX(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return aead
+	}
+
+	// The nonce of a filename is a MAC keyed with the nonce subkey, and the
+	// filename is encrypted with the filename subkey.
+	mac, err := blake2b.New(chacha20poly1305.NonceSizeX, subkey("skyenc-filename-nonce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = mac.Write([]byte("file.txt"))
+	nonce := mac.Sum(nil)
+	expected := base64.RawURLEncoding.EncodeToString(newAEAD(subkey("skyenc-filename-aead")).Seal(nonce, nonce, []byte("file.txt"), nil))
+	encrypted, err := EncryptFilename(key, "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted != expected {
+		t.Fatalf("expected encrypted filename %v, got %v", expected, encrypted)
+	}
+
+	// The contents are encrypted with the content subkey.
+	data := []byte("test\n")
+	encryptedData := encryptData(t, key, data)
+	noncePrefix := encryptedData[len(encryptionMagic)+1 : encryptionHeaderSize]
+	chunkNonce := append(append([]byte{}, noncePrefix...), 0, 0, 0, 0, 0, 0, 0, 0)
+	for _, k := range [][]byte{key[:], subkey("skyenc-filename-aead")} {
+		if _, err := newAEAD(k).Open(nil, chunkNonce, encryptedData[encryptionHeaderSize:], finalChunkData); err == nil {
+			t.Fatal("expected contents not to be encrypted with the key or the filename subkey")
+		}
+	}
+	decrypted, err := newAEAD(subkey("skyenc-content-aead")).Open(nil, chunkNonce, encryptedData[encryptionHeaderSize:], finalChunkData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatalf("expected %q, got %q", data, decrypted)
+	}
+}
@@ -1,6 +1,8 @@
 package skynet
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net/http"
@@ -15,16 +17,26 @@ type (
 	SkynetClient struct {
 		PortalURL string
 		Options   Options
+
+		// subscriptions contains the registry subscriptions of the client. It
+		// is shared by copies of the client.
+		subscriptions *registrySubscriptions
 	}
 
 	// requestOptions contains the options for a request.
 	requestOptions struct {
 		Options
 
+		ctx       context.Context
 		method    string
 		reqBody   io.Reader
 		extraPath string
 		query     url.Values
+		header    http.Header
+
+		// attempts receives the number of attempts made to send the
+		// request, if set.
+		attempts *int
 	}
 )
 
@@ -40,18 +52,62 @@ func NewCustom(portalURL string, customOptions Options) SkynetClient {
 		portalURL = DefaultPortalURL()
 	}
 	return SkynetClient{
-		PortalURL: ensurePrefix(strings.TrimPrefix(portalURL, "http://"), "https://"),
-		Options:   customOptions,
+		PortalURL:     ensurePrefix(strings.TrimPrefix(portalURL, "http://"), "https://"),
+		Options:       customOptions,
+		subscriptions: newRegistrySubscriptions(),
 	}
 }
 
+// revisionCache returns the revision cache of the client, which is shared by
+// all clients of the process that use the same portal.
+func (sc *SkynetClient) revisionCache() *revisionCache {
+	return revisionCacheOf(sc.PortalURL)
+}
+
 // executeRequest makes and executes a request.
 func (sc *SkynetClient) executeRequest(config requestOptions) (*http.Response, error) {
 	url := sc.PortalURL
 	method := config.method
 	reqBody := config.reqBody
 
-	// Set options, prioritizing options passed to the API calls.
+	opts := sc.mergeOptions(config.Options)
+
+	// Make the URL.
+	url = makeURL(url, opts.EndpointPath, config.extraPath, config.query)
+
+	// Use a background context if the caller didn't provide one.
+	ctx := config.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// Don't send the request if the context was already cancelled.
+	if err := ctx.Err(); err != nil {
+		return nil, errors.AddContext(err, "could not execute request")
+	}
+
+	// Create the request.
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, errors.AddContext(err, fmt.Sprintf("could not create %v request", method))
+	}
+	for key, values := range config.header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	setOptionHeaders(req.Header, opts)
+
+	// Execute the request, retrying it if necessary.
+	resp, attempts, err := doWithRetries(opts.httpClient(), req, opts.RetryPolicy)
+	if config.attempts != nil {
+		*config.attempts = attempts
+	}
+	return resp, err
+}
+
+// mergeOptions returns the options of the client, overridden by the options
+// passed to an API call.
+func (sc *SkynetClient) mergeOptions(config Options) Options {
 	opts := sc.Options
 	if config.EndpointPath != "" {
 		opts.EndpointPath = config.EndpointPath
@@ -65,39 +121,32 @@ func (sc *SkynetClient) executeRequest(config requestOptions) (*http.Response, e
 	if config.CustomUserAgent != "" {
 		opts.CustomUserAgent = config.CustomUserAgent
 	}
+	if config.HTTPClient != nil {
+		opts.HTTPClient = config.HTTPClient
+	}
+	if config.RetryPolicy.MaxAttempts != 0 {
+		opts.RetryPolicy = config.RetryPolicy
+	}
 	if config.customContentType != "" {
 		opts.customContentType = config.customContentType
 	}
+	return opts
+}
 
-	// Make the URL.
-	url = makeURL(url, opts.EndpointPath, config.extraPath, config.query)
-
-	// Create the request.
-	req, err := http.NewRequest(method, url, reqBody)
-	if err != nil {
-		return nil, errors.AddContext(err, fmt.Sprintf("could not create %v request", method))
-	}
+// setOptionHeaders sets the headers of a request that are configured by the
+// options.
+func setOptionHeaders(header http.Header, opts Options) {
 	if opts.APIKey != "" {
-		req.SetBasicAuth("", opts.APIKey)
+		auth := base64.StdEncoding.EncodeToString([]byte(":" + opts.APIKey))
+		header.Set("Authorization", "Basic "+auth)
 	}
 	if opts.SkynetAPIKey != "" {
-		req.Header.Set("Skynet-Api-Key", opts.SkynetAPIKey)
+		header.Set("Skynet-Api-Key", opts.SkynetAPIKey)
 	}
 	if opts.CustomUserAgent != "" {
-		req.Header.Set("User-Agent", opts.CustomUserAgent)
+		header.Set("User-Agent", opts.CustomUserAgent)
 	}
 	if opts.customContentType != "" {
-		req.Header.Set("Content-Type", opts.customContentType)
+		header.Set("Content-Type", opts.customContentType)
 	}
-
-	// Execute the request.
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, errors.AddContext(err, "could not execute request")
-	}
-	if resp.StatusCode >= 400 {
-		return nil, errors.AddContext(makeResponseError(resp), "error code received")
-	}
-
-	return resp, nil
 }
@@ -2,11 +2,18 @@ package skynet
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	gopath "path"
+	"strconv"
 	"strings"
+	"time"
 
 	"gitlab.com/NebulousLabs/errors"
 )
@@ -20,21 +27,151 @@ type (
 		SkykeyName string
 		// SkykeyID is the ID of the skykey used to encrypt the upload.
 		SkykeyID string
+		// EncryptionKey is the key the upload was encrypted with on the
+		// client. The downloaded file is decrypted and its path within the
+		// skyfile, if any, encrypted. Files of encrypted directories have to
+		// be downloaded one at a time by path.
+		EncryptionKey *EncryptionKey
+
+		// Progress is called with the progress of the download as the body
+		// is read, at most once per ProgressInterval. It is called once
+		// before any data is read with the advertised content length, and
+		// once when the download is complete. Calls are made one at a time
+		// from the goroutine reading the body.
+		Progress DownloadProgressFunc
+		// ProgressInterval is the minimum time between progress reports. If
+		// zero, DefaultProgressInterval is used.
+		ProgressInterval time.Duration
+
+		// Resume makes DownloadFile resumable. The data is downloaded to a
+		// partial file next to the destination, along with a state file
+		// identifying the download, and only renamed into place once
+		// complete. A later download of the same skylink to the same path
+		// continues where the previous one stopped.
+		Resume bool
+	}
+
+	// DownloadVerifiedOptions contains the options used for verified
+	// downloads.
+	DownloadVerifiedOptions struct {
+		Options
 	}
 
 	// MetadataOptions contains the options used for getting metadata.
 	MetadataOptions struct {
 		Options
 	}
+
+	// ContentRange describes the part of a file returned for a ranged
+	// download.
+	ContentRange struct {
+		// Start is the offset of the first byte returned.
+		Start int64
+		// End is the offset of the last byte returned.
+		End int64
+		// Size is the total size of the file, or -1 if it is unknown.
+		Size int64
+	}
+
+	// downloadState identifies the download of a partial file.
+	downloadState struct {
+		// Skylink is the requested skylink.
+		Skylink string `json:"skylink"`
+		// ResolvedSkylink is the skylink returned by the portal.
+		ResolvedSkylink string `json:"resolvedskylink"`
+		// ETag is the ETag returned by the portal.
+		ETag string `json:"etag"`
+	}
+
+	// MetadataResponse contains the response for getting metadata.
+	MetadataResponse struct {
+		// Skylink is the resolved skylink.
+		Skylink Skylink
+		// ContentType is the Content-Type the portal serves the skyfile
+		// with.
+		ContentType string
+		// Metadata is the metadata of the skyfile.
+		Metadata SkyfileMetadata
+	}
+
+	// SkyfileMetadata contains the metadata of a skyfile.
+	SkyfileMetadata struct {
+		// Filename is the name of the skyfile.
+		Filename string `json:"filename"`
+		// Length is the total length of the skyfile's content.
+		Length uint64 `json:"length"`
+		// Mode is the file mode of the skyfile.
+		Mode os.FileMode `json:"mode,omitempty"`
+		// Subfiles contains the metadata of the files of a directory upload,
+		// indexed by their paths.
+		Subfiles map[string]SkyfileSubfileMetadata `json:"subfiles,omitempty"`
+		// DefaultPath is the path of the subfile served when the skyfile is
+		// downloaded without a path.
+		DefaultPath string `json:"defaultpath,omitempty"`
+		// DisableDefaultPath is true if no default path is served.
+		DisableDefaultPath bool `json:"disabledefaultpath,omitempty"`
+		// TryFiles are the files tried, in order, for paths that don't match
+		// a subfile.
+		TryFiles []string `json:"tryfiles,omitempty"`
+		// ErrorPages are the subfiles served for error status codes.
+		ErrorPages map[int]string `json:"errorpages,omitempty"`
+	}
+
+	// SkyfileSubfileMetadata contains the metadata of a file within a
+	// skyfile.
+	SkyfileSubfileMetadata struct {
+		// FileMode is the file mode of the subfile.
+		FileMode os.FileMode `json:"mode,omitempty"`
+		// Filename is the path of the subfile.
+		Filename string `json:"filename,omitempty"`
+		// ContentType is the Content-Type of the subfile.
+		ContentType string `json:"contenttype,omitempty"`
+		// Offset is the offset of the subfile within the skyfile's content.
+		Offset uint64 `json:"offset,omitempty"`
+		// Len is the length of the subfile.
+		Len uint64 `json:"len,omitempty"`
+	}
+)
+
+const (
+	// PartialDownloadSuffix is appended to the destination path of a
+	// resumable download to get the path of the partial file.
+	PartialDownloadSuffix = ".partial"
+
+	// downloadStateSuffix is appended to the path of a partial file to get
+	// the path of its state file.
+	downloadStateSuffix = ".json"
 )
 
 var (
+	// ErrIntegrity is returned for a verified download if the data returned
+	// by the portal does not match the skylink.
+	ErrIntegrity = errors.New("downloaded data does not match the skylink")
+
+	// ErrVerificationUnsupported is returned for a verified download of a
+	// skylink whose content can't be verified against the skylink alone.
+	ErrVerificationUnsupported = errors.New("verification is not supported for this skylink")
+
+	// ErrRangeIgnored is returned for a ranged download if the portal ignored
+	// the requested range and responded with the whole file.
+	ErrRangeIgnored = errors.New("portal ignored the requested range")
+
 	// DefaultDownloadOptions contains the default download options.
 	DefaultDownloadOptions = DownloadOptions{
 		Options: DefaultOptions("/"),
 
-		SkykeyName: "",
-		SkykeyID:   "",
+		SkykeyName:       "",
+		SkykeyID:         "",
+		EncryptionKey:    nil,
+		Progress:         nil,
+		ProgressInterval: 0,
+		Resume:           false,
+	}
+
+	// DefaultDownloadVerifiedOptions contains the default verified download
+	// options.
+	DefaultDownloadVerifiedOptions = DownloadVerifiedOptions{
+		Options: DefaultOptions("/skynet/basesector"),
 	}
 
 	// DefaultMetadataOptions contains the default getting metadata options.
@@ -45,33 +182,95 @@ var (
 
 // Download downloads generic data.
 func (sc *SkynetClient) Download(skylink string, opts DownloadOptions) (io.ReadCloser, error) {
-	skylink = strings.TrimPrefix(skylink, URISkynetPrefix)
+	return sc.DownloadWithContext(context.Background(), skylink, opts)
+}
 
-	values := url.Values{}
-	values.Set("skykeyname", opts.SkykeyName)
-	values.Set("skykeyid", opts.SkykeyID)
+// DownloadWithContext downloads generic data. The download is aborted and the
+// returned body closed when the context is cancelled.
+func (sc *SkynetClient) DownloadWithContext(ctx context.Context, skylink string, opts DownloadOptions) (io.ReadCloser, error) {
+	extracted, err := ExtractSkylink(skylink)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not parse skylink")
+	}
+	if opts.EncryptionKey != nil {
+		extracted.Path, err = EncryptFilename(*opts.EncryptionKey, extracted.Path)
+		if err != nil {
+			return nil, errors.AddContext(err, "could not encrypt path")
+		}
+	}
 
 	resp, err := sc.executeRequest(
 		requestOptions{
 			Options:   opts.Options,
+			ctx:       ctx,
 			method:    "GET",
 			reqBody:   &bytes.Buffer{},
-			extraPath: skylink,
-			query:     values,
+			extraPath: extracted.requestPath(),
+			query:     downloadQuery(extracted, opts),
 		},
 	)
 	if err != nil {
 		return nil, errors.AddContext(err, "could not execute request")
 	}
 
-	return resp.Body, nil
+	body := resp.Body
+	if opts.Progress != nil {
+		body = newDownloadProgressReader(body, DownloadProgress{ContentLength: resp.ContentLength}, opts.Progress, opts.ProgressInterval)
+	}
+	if opts.EncryptionKey != nil {
+		body, err = newDecryptReader(*opts.EncryptionKey, body)
+		if err != nil {
+			_ = resp.Body.Close()
+			return nil, errors.AddContext(err, "could not decrypt download")
+		}
+	}
+	return body, nil
+}
+
+// DownloadRange downloads length bytes of generic data starting at offset. If
+// length is 0, the data is downloaded until the end of the file. The returned
+// range may be shorter than requested if the file ends before the range does.
+func (sc *SkynetClient) DownloadRange(skylink string, offset, length int64, opts DownloadOptions) (io.ReadCloser, ContentRange, error) {
+	return sc.DownloadRangeWithContext(context.Background(), skylink, offset, length, opts)
+}
+
+// DownloadRangeWithContext downloads length bytes of generic data starting at
+// offset. If length is 0, the data is downloaded until the end of the file. The
+// download is aborted and the returned body closed when the context is
+// cancelled.
+func (sc *SkynetClient) DownloadRangeWithContext(ctx context.Context, skylink string, offset, length int64, opts DownloadOptions) (io.ReadCloser, ContentRange, error) {
+	if opts.EncryptionKey != nil {
+		return nil, ContentRange{}, errors.New("ranged downloads of encrypted uploads are not supported")
+	}
+	resp, contentRange, err := sc.downloadRange(ctx, skylink, offset, length, opts)
+	if err != nil {
+		return nil, ContentRange{}, err
+	}
+
+	if opts.Progress != nil {
+		return newDownloadProgressReader(resp.Body, DownloadProgress{ContentLength: resp.ContentLength}, opts.Progress, opts.ProgressInterval), contentRange, nil
+	}
+	return resp.Body, contentRange, nil
 }
 
 // DownloadFile downloads a file from Skynet to path.
-func (sc *SkynetClient) DownloadFile(path, skylink string, opts DownloadOptions) (err error) {
+func (sc *SkynetClient) DownloadFile(path, skylink string, opts DownloadOptions) error {
+	return sc.DownloadFileWithContext(context.Background(), path, skylink, opts)
+}
+
+// DownloadFileWithContext downloads a file from Skynet to path. The download is
+// aborted when the context is cancelled.
+func (sc *SkynetClient) DownloadFileWithContext(ctx context.Context, path, skylink string, opts DownloadOptions) (err error) {
 	path = gopath.Clean(path)
 
-	downloadData, err := sc.Download(skylink, opts)
+	if opts.Resume {
+		if opts.EncryptionKey != nil {
+			return errors.New("resumable downloads of encrypted uploads are not supported")
+		}
+		return sc.downloadFileResumable(ctx, path, skylink, opts)
+	}
+
+	downloadData, err := sc.DownloadWithContext(ctx, skylink, opts)
 	if err != nil {
 		return errors.AddContext(err, "could not download data")
 	}
@@ -91,7 +290,450 @@ func (sc *SkynetClient) DownloadFile(path, skylink string, opts DownloadOptions)
 	return errors.AddContext(err, "could not copy data to file at "+path)
 }
 
+// DownloadVerified downloads generic data and verifies it against the
+// skylink. See DownloadVerifiedWithContext.
+func (sc *SkynetClient) DownloadVerified(skylink string, opts DownloadVerifiedOptions) (io.ReadCloser, error) {
+	return sc.DownloadVerifiedWithContext(context.Background(), skylink, opts)
+}
+
+// DownloadVerifiedWithContext downloads generic data and verifies it against
+// the skylink. The base sector of the skyfile is downloaded and its merkle root
+// compared to the one in the skylink, returning ErrIntegrity on mismatch. Only
+// unencrypted content of v1 skylinks that fits in the base sector can be
+// verified, ErrVerificationUnsupported is returned for any other skylink. If
+// the skylink has a path, only the file at that path is returned.
+func (sc *SkynetClient) DownloadVerifiedWithContext(ctx context.Context, skylink string, opts DownloadVerifiedOptions) (io.ReadCloser, error) {
+	extracted, err := ExtractSkylink(skylink)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not parse skylink")
+	}
+	sl := extracted.Skylink
+	if !sl.IsSkylinkV1() {
+		return nil, errors.AddContext(ErrVerificationUnsupported, "only v1 skylinks can be verified")
+	}
+	// The merkle root is computed over the whole sector, so the data has to
+	// start at the beginning of it.
+	offset, fetchSize, err := sl.OffsetAndFetchSize()
+	if err != nil {
+		return nil, errors.AddContext(err, "invalid skylink")
+	}
+	if offset != 0 {
+		return nil, errors.AddContext(ErrVerificationUnsupported, "skylink does not start at the beginning of the sector")
+	}
+
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options:   opts.Options,
+			ctx:       ctx,
+			method:    "GET",
+			reqBody:   &bytes.Buffer{},
+			extraPath: sl.String(),
+		},
+	)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not execute request")
+	}
+	// Read at most a full sector, any more data can't be valid. The rest of
+	// the sector is zero-padded.
+	baseSector := make([]byte, sectorSize)
+	_, err = io.ReadFull(resp.Body, baseSector)
+	if err == nil {
+		var extra [1]byte
+		if n, _ := resp.Body.Read(extra[:]); n > 0 {
+			err = errors.AddContext(ErrIntegrity, "base sector is larger than a sector")
+		}
+	} else if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	err = errors.Compose(err, resp.Body.Close())
+	if err != nil {
+		return nil, errors.AddContext(err, "could not read base sector")
+	}
+
+	if merkleRoot(baseSector) != sl.MerkleRoot() {
+		return nil, errors.AddContext(ErrIntegrity, "merkle root of the base sector does not match the skylink")
+	}
+
+	layout, metadata, content, err := parseBaseSector(baseSector[:fetchSize])
+	if err != nil {
+		return nil, errors.Compose(ErrVerificationUnsupported, errors.AddContext(err, "could not parse base sector"))
+	}
+	if layout.fanoutSize != 0 {
+		return nil, errors.AddContext(ErrVerificationUnsupported, "content does not fit in the base sector")
+	}
+
+	// Return the file at the path, if any.
+	if extracted.Path != "" {
+		subfile, ok := metadata.Subfiles[strings.TrimPrefix(extracted.Path, "/")]
+		if !ok {
+			return nil, fmt.Errorf("file %v not found in skyfile", extracted.Path)
+		}
+		if subfile.Offset > uint64(len(content)) || subfile.Len > uint64(len(content))-subfile.Offset {
+			return nil, fmt.Errorf("file %v exceeds the content of the skyfile", extracted.Path)
+		}
+		content = content[subfile.Offset : subfile.Offset+subfile.Len]
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
 // Metadata downloads metadata from the given skylink.
-func (sc *SkynetClient) Metadata(skylink string, opts MetadataOptions) error {
-	panic("Not implemented")
+func (sc *SkynetClient) Metadata(skylink string, opts MetadataOptions) (MetadataResponse, error) {
+	return sc.MetadataWithContext(context.Background(), skylink, opts)
+}
+
+// MetadataWithContext downloads metadata from the given skylink.
+func (sc *SkynetClient) MetadataWithContext(ctx context.Context, skylink string, opts MetadataOptions) (MetadataResponse, error) {
+	extracted, err := ExtractSkylink(skylink)
+	if err != nil {
+		return MetadataResponse{}, errors.AddContext(err, "could not parse skylink")
+	}
+
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options:   opts.Options,
+			ctx:       ctx,
+			method:    "HEAD",
+			reqBody:   &bytes.Buffer{},
+			extraPath: extracted.requestPath(),
+			query:     extracted.Query,
+		},
+	)
+	if err != nil {
+		return MetadataResponse{}, errors.AddContext(err, "could not execute request")
+	}
+	// The response to a HEAD request has no body, but close it anyway.
+	err = resp.Body.Close()
+	if err != nil {
+		return MetadataResponse{}, errors.AddContext(err, "could not close response body")
+	}
+
+	return parseMetadataHeaders(resp.Header)
+}
+
+// parseMetadataHeaders parses the metadata of a skyfile from the headers of a
+// response.
+func parseMetadataHeaders(header http.Header) (MetadataResponse, error) {
+	skylinkStr := header.Get("Skynet-Skylink")
+	if skylinkStr == "" {
+		return MetadataResponse{}, errors.New("response is missing the Skynet-Skylink header")
+	}
+	skylink, err := ParseSkylink(skylinkStr)
+	if err != nil {
+		return MetadataResponse{}, errors.AddContext(err, "could not parse Skynet-Skylink header")
+	}
+	metadataJSON := header.Get("Skynet-File-Metadata")
+	if metadataJSON == "" {
+		return MetadataResponse{}, errors.New("response is missing the Skynet-File-Metadata header")
+	}
+
+	var metadata SkyfileMetadata
+	err = json.Unmarshal([]byte(metadataJSON), &metadata)
+	if err != nil {
+		return MetadataResponse{}, errors.AddContext(err, "could not parse Skynet-File-Metadata header")
+	}
+
+	return MetadataResponse{
+		Skylink:     skylink,
+		ContentType: header.Get("Content-Type"),
+		Metadata:    metadata,
+	}, nil
+}
+
+// downloadRange executes a ranged download request and validates that the
+// portal responded with the requested range.
+func (sc *SkynetClient) downloadRange(ctx context.Context, skylink string, offset, length int64, opts DownloadOptions) (*http.Response, ContentRange, error) {
+	if offset < 0 || length < 0 {
+		return nil, ContentRange{}, fmt.Errorf("invalid range with offset %v and length %v", offset, length)
+	}
+	extracted, err := ExtractSkylink(skylink)
+	if err != nil {
+		return nil, ContentRange{}, errors.AddContext(err, "could not parse skylink")
+	}
+
+	header := make(http.Header)
+	end := int64(-1)
+	if length > 0 {
+		end = offset + length - 1
+		header.Set("Range", fmt.Sprintf("bytes=%v-%v", offset, end))
+	} else {
+		header.Set("Range", fmt.Sprintf("bytes=%v-", offset))
+	}
+
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options:   opts.Options,
+			ctx:       ctx,
+			method:    "GET",
+			reqBody:   &bytes.Buffer{},
+			extraPath: extracted.requestPath(),
+			query:     downloadQuery(extracted, opts),
+			header:    header,
+		},
+	)
+	if err != nil {
+		return nil, ContentRange{}, errors.AddContext(err, "could not execute request")
+	}
+
+	contentRange, err := checkContentRange(resp, offset, end)
+	if err != nil {
+		return nil, ContentRange{}, errors.Compose(err, resp.Body.Close())
+	}
+	return resp, contentRange, nil
+}
+
+// checkContentRange checks that the response contains the range starting at
+// offset and ending at end, or at the end of the file if end is -1.
+func checkContentRange(resp *http.Response, offset, end int64) (ContentRange, error) {
+	if resp.StatusCode != http.StatusPartialContent {
+		return ContentRange{}, errors.AddContext(ErrRangeIgnored, fmt.Sprintf("expected status code %v, got %v", http.StatusPartialContent, resp.StatusCode))
+	}
+	contentRange, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return ContentRange{}, errors.AddContext(err, "could not parse Content-Range header")
+	}
+	if contentRange.Start != offset {
+		return ContentRange{}, fmt.Errorf("expected range to start at %v, got %v", offset, contentRange.Start)
+	}
+	if end >= 0 && contentRange.End > end {
+		return ContentRange{}, fmt.Errorf("expected range to end at %v, got %v", end, contentRange.End)
+	}
+	// The range may only end before the requested end if the file ends first.
+	// Open-ended ranges of files with an unknown size can't be checked.
+	endsFile := contentRange.End == contentRange.Size-1 || end < 0 && contentRange.Size < 0
+	if (end < 0 || contentRange.End < end) && !endsFile {
+		return ContentRange{}, fmt.Errorf("expected range to end at the end of the file, got %v of %v bytes", contentRange.End, contentRange.Size)
+	}
+	return contentRange, nil
+}
+
+// parseContentRange parses the value of a Content-Range header of the form
+// "bytes <start>-<end>/<size>", where the size may be "*".
+func parseContentRange(value string) (ContentRange, error) {
+	var contentRange ContentRange
+	if !strings.HasPrefix(value, "bytes ") {
+		return ContentRange{}, fmt.Errorf("unsupported Content-Range %q", value)
+	}
+	value = strings.TrimPrefix(value, "bytes ")
+	slash := strings.Index(value, "/")
+	dash := strings.Index(value, "-")
+	if slash < 0 || dash < 0 || dash > slash {
+		return ContentRange{}, fmt.Errorf("malformed Content-Range %q", value)
+	}
+	var err error
+	if contentRange.Start, err = strconv.ParseInt(value[:dash], 10, 64); err != nil {
+		return ContentRange{}, errors.AddContext(err, "invalid range start")
+	}
+	if contentRange.End, err = strconv.ParseInt(value[dash+1:slash], 10, 64); err != nil {
+		return ContentRange{}, errors.AddContext(err, "invalid range end")
+	}
+	contentRange.Size = -1
+	if size := value[slash+1:]; size != "*" {
+		if contentRange.Size, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return ContentRange{}, errors.AddContext(err, "invalid size")
+		}
+	}
+	if contentRange.Start < 0 || contentRange.End < contentRange.Start || contentRange.Size >= 0 && contentRange.End >= contentRange.Size {
+		return ContentRange{}, fmt.Errorf("invalid Content-Range %q", value)
+	}
+	return contentRange, nil
+}
+
+// downloadFileResumable downloads a file from Skynet to path, continuing any
+// partial download of the same skylink.
+func (sc *SkynetClient) downloadFileResumable(ctx context.Context, path, skylink string, opts DownloadOptions) (err error) {
+	extracted, err := ExtractSkylink(skylink)
+	if err != nil {
+		return errors.AddContext(err, "could not parse skylink")
+	}
+	// Identify the download by the normalized skylink and path.
+	skylink = extracted.requestPath()
+	partialPath := path + PartialDownloadSuffix
+	statePath := partialPath + downloadStateSuffix
+
+	// Continue the partial file if it was downloaded from the same skylink.
+	var state downloadState
+	var offset int64
+	if prevState, err := readDownloadState(statePath); err == nil && prevState.Skylink == skylink {
+		if info, err := os.Stat(partialPath); err == nil {
+			state = prevState
+			offset = info.Size()
+		}
+	}
+
+	resp, err := sc.requestResumableDownload(ctx, extracted, offset, state.ETag, opts)
+	if respErr, ok := AsResponseError(err); ok && offset > 0 && respErr.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		if isRangeComplete(respErr, offset) && state.matches(respErr.Header) {
+			// The partial file already contains all data.
+			return finishResumableDownload(path, partialPath, statePath)
+		}
+		// The partial file is larger than the file or the file changed, so
+		// remove it and start over.
+		err = errors.Compose(os.Remove(partialPath), os.Remove(statePath))
+		if err != nil {
+			return errors.AddContext(err, "could not remove partial download")
+		}
+		offset = 0
+		resp, err = sc.requestResumableDownload(ctx, extracted, offset, "", opts)
+	}
+	if err != nil {
+		return errors.AddContext(err, "could not download data")
+	}
+	if offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		_, err = checkContentRange(resp, offset, -1)
+		if err == nil && !state.matches(resp.Header) {
+			err = errors.New("file changed since the partial download")
+		}
+		if err != nil {
+			// Start over if the response can't be appended to the partial
+			// file.
+			closeErr := resp.Body.Close()
+			offset = 0
+			resp, err = sc.requestResumableDownload(ctx, extracted, offset, "", opts)
+			if err != nil {
+				return errors.AddContext(errors.Compose(err, closeErr), "could not download data")
+			}
+		}
+	} else {
+		// The portal sent the whole file.
+		offset = 0
+	}
+	body := resp.Body
+	defer func() {
+		err = errors.Extend(err, body.Close())
+	}()
+
+	// Save the state before writing any data.
+	state = downloadState{
+		Skylink:         skylink,
+		ResolvedSkylink: resp.Header.Get("Skynet-Skylink"),
+		ETag:            resp.Header.Get("ETag"),
+	}
+	err = writeDownloadState(statePath, state)
+	if err != nil {
+		return errors.AddContext(err, "could not save download state")
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	out, err := os.OpenFile(partialPath, flags, 0666)
+	if err != nil {
+		return errors.AddContext(err, "could not open partial file at "+partialPath)
+	}
+	if opts.Progress != nil {
+		progress := DownloadProgress{BytesReceived: offset, ContentLength: -1}
+		if resp.ContentLength >= 0 {
+			progress.ContentLength = offset + resp.ContentLength
+		}
+		body = newDownloadProgressReader(body, progress, opts.Progress, opts.ProgressInterval)
+	}
+	n, err := io.Copy(out, body)
+	err = errors.Compose(err, out.Close())
+	if err != nil {
+		return errors.AddContext(err, "could not copy data to partial file at "+partialPath)
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("expected %v bytes, received %v", resp.ContentLength, n)
+	}
+
+	return finishResumableDownload(path, partialPath, statePath)
+}
+
+// requestResumableDownload requests the data of the skylink starting at
+// offset. The whole file is requested if the data changed since the given
+// ETag.
+func (sc *SkynetClient) requestResumableDownload(ctx context.Context, extracted ExtractedSkylink, offset int64, etag string, opts DownloadOptions) (*http.Response, error) {
+	header := make(http.Header)
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%v-", offset))
+		if etag != "" {
+			header.Set("If-Range", etag)
+		}
+	}
+
+	return sc.executeRequest(
+		requestOptions{
+			Options:   opts.Options,
+			ctx:       ctx,
+			method:    "GET",
+			reqBody:   &bytes.Buffer{},
+			extraPath: extracted.requestPath(),
+			query:     downloadQuery(extracted, opts),
+			header:    header,
+		},
+	)
+}
+
+// finishResumableDownload moves the complete partial file into place and
+// removes its state file.
+func finishResumableDownload(path, partialPath, statePath string) error {
+	err := os.Rename(partialPath, path)
+	if err != nil {
+		return errors.AddContext(err, "could not move partial file to "+path)
+	}
+	err = os.Remove(statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.AddContext(err, "could not remove download state")
+	}
+	return nil
+}
+
+// isRangeComplete returns true if the 416 response indicates that the file is
+// exactly offset bytes long.
+func isRangeComplete(respErr *ResponseError, offset int64) bool {
+	return respErr.Header.Get("Content-Range") == fmt.Sprintf("bytes */%v", offset)
+}
+
+// matches returns true if the response headers identify the same file as the
+// download state. Headers missing from either are not compared.
+func (ds downloadState) matches(header http.Header) bool {
+	resolvedSkylink := header.Get("Skynet-Skylink")
+	if ds.ResolvedSkylink != "" && resolvedSkylink != "" && ds.ResolvedSkylink != resolvedSkylink {
+		return false
+	}
+	etag := header.Get("ETag")
+	if ds.ETag != "" && etag != "" && ds.ETag != etag {
+		return false
+	}
+	return true
+}
+
+// readDownloadState reads the download state from the file at path.
+func readDownloadState(path string) (downloadState, error) {
+	var state downloadState
+	data, err := ioutil.ReadFile(gopath.Clean(path))
+	if err != nil {
+		return downloadState{}, err
+	}
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return downloadState{}, errors.AddContext(err, "could not parse download state")
+	}
+	return state, nil
+}
+
+// writeDownloadState writes the download state to the file at path.
+func writeDownloadState(path string, state downloadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return errors.AddContext(err, "could not encode download state")
+	}
+	return ioutil.WriteFile(path, data, 0666)
+}
+
+// downloadQuery returns the query for downloading the extracted skylink. The
+// skykey options take precedence over any skykey in the extracted query.
+func downloadQuery(extracted ExtractedSkylink, opts DownloadOptions) url.Values {
+	values := url.Values{}
+	for key, value := range extracted.Query {
+		values[key] = value
+	}
+	if opts.SkykeyName != "" || values.Get("skykeyname") == "" {
+		values.Set("skykeyname", opts.SkykeyName)
+	}
+	if opts.SkykeyID != "" || values.Get("skykeyid") == "" {
+		values.Set("skykeyid", opts.SkykeyID)
+	}
+	return values
 }
@@ -0,0 +1,187 @@
+package skynet
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestParseContentRange tests parsing Content-Range header values.
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		value        string
+		contentRange ContentRange
+		valid        bool
+	}{
+		{"bytes 0-9/100", ContentRange{0, 9, 100}, true},
+		{"bytes 90-99/100", ContentRange{90, 99, 100}, true},
+		{"bytes 5-5/*", ContentRange{5, 5, -1}, true},
+		{"", ContentRange{}, false},
+		{"bytes */100", ContentRange{}, false},
+		{"bytes 9-0/100", ContentRange{}, false},
+		{"bytes 0-100/100", ContentRange{}, false},
+		{"bytes a-9/100", ContentRange{}, false},
+		{"items 0-9/100", ContentRange{}, false},
+	}
+
+	for _, test := range tests {
+		contentRange, err := parseContentRange(test.value)
+		if test.valid && err != nil {
+			t.Fatalf("%q: unexpected error %v", test.value, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("%q: expected error", test.value)
+		}
+		if contentRange != test.contentRange {
+			t.Fatalf("%q: expected %v, got %v", test.value, test.contentRange, contentRange)
+		}
+	}
+}
+
+// TestCheckContentRange tests validating the range of a response against the
+// requested range.
+func TestCheckContentRange(t *testing.T) {
+	tests := []struct {
+		status       int
+		contentRange string
+		offset       int64
+		end          int64
+		valid        bool
+	}{
+		{http.StatusPartialContent, "bytes 0-9/100", 0, 9, true},
+		// The file ends before the requested range.
+		{http.StatusPartialContent, "bytes 90-99/100", 90, 199, true},
+		// Open-ended ranges.
+		{http.StatusPartialContent, "bytes 10-99/100", 10, -1, true},
+		{http.StatusPartialContent, "bytes 10-99/*", 10, -1, true},
+		{http.StatusPartialContent, "bytes 10-49/100", 10, -1, false},
+		// Ranges not matching the requested range.
+		{http.StatusPartialContent, "bytes 1-9/100", 0, 9, false},
+		{http.StatusPartialContent, "bytes 0-19/100", 0, 9, false},
+		{http.StatusPartialContent, "bytes 0-4/100", 0, 9, false},
+		{http.StatusPartialContent, "bytes 0-4/*", 0, 9, false},
+		{http.StatusPartialContent, "", 0, 9, false},
+	}
+
+	for _, test := range tests {
+		resp := &http.Response{
+			StatusCode: test.status,
+			Header:     http.Header{"Content-Range": []string{test.contentRange}},
+		}
+		_, err := checkContentRange(resp, test.offset, test.end)
+		if test.valid && err != nil {
+			t.Fatalf("%q for %v-%v: unexpected error %v", test.contentRange, test.offset, test.end, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("%q for %v-%v: expected error", test.contentRange, test.offset, test.end)
+		}
+	}
+
+	// A full response should be reported as an ignored range.
+	resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header)}
+	_, err := checkContentRange(resp, 0, 9)
+	if !errors.Contains(err, ErrRangeIgnored) {
+		t.Fatalf("expected error %v, got %v", ErrRangeIgnored, err)
+	}
+}
+
+// TestDownloadVerified tests verifying downloaded data against the merkle root
+// in the skylink.
+func TestDownloadVerified(t *testing.T) {
+	// Build the base sector of a directory with two files.
+	content := []byte("test\ntest2\n")
+	metadata, err := json.Marshal(SkyfileMetadata{
+		Filename: "dir",
+		Length:   uint64(len(content)),
+		Subfiles: map[string]SkyfileSubfileMetadata{
+			"file1.txt": {Filename: "file1.txt", Len: 5},
+			"file2.txt": {Filename: "file2.txt", Offset: 5, Len: 6},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := skyfileLayout{
+		version:      skyfileVersion,
+		filesize:     uint64(len(content)),
+		metadataSize: uint64(len(metadata)),
+		cipherType:   cipherTypePlain,
+	}
+	baseSector := append(append(layout.encode(), metadata...), content...)
+	padded := make([]byte, sectorSize)
+	copy(padded, baseSector)
+	sl, err := NewSkylinkV1(merkleRoot(padded), 0, uint64(len(baseSector)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Serve the base sector, tampering with it if requested.
+	var tamper bool
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/skynet/basesector/"+sl.String() {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data := append([]byte{}, baseSector...)
+		if tamper {
+			data[len(data)-1] ^= 1
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+	client := NewCustom(server.URL, Options{HTTPClient: server.Client()})
+	opts := DefaultDownloadVerifiedOptions
+
+	tests := []struct {
+		skylink string
+		data    string
+	}{
+		{sl.URI(), string(content)},
+		{sl.String() + "/file2.txt", "test2\n"},
+		{server.URL + "/" + sl.String() + "/file1.txt", "test\n"},
+	}
+	for _, test := range tests {
+		body, err := client.DownloadVerified(test.skylink, opts)
+		if err != nil {
+			t.Fatalf("%v: %v", test.skylink, err)
+		}
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != test.data {
+			t.Fatalf("%v: expected data %q, got %q", test.skylink, test.data, data)
+		}
+	}
+
+	// A missing file should fail.
+	_, err = client.DownloadVerified(sl.String()+"/file3.txt", opts)
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	// Tampered data should fail the verification.
+	tamper = true
+	_, err = client.DownloadVerified(sl.String(), opts)
+	if !errors.Contains(err, ErrIntegrity) {
+		t.Fatalf("expected error %v, got %v", ErrIntegrity, err)
+	}
+
+	// Skylinks that can't be verified should be rejected.
+	v2 := Skylink{bitfield: 1}
+	offset, err := NewSkylinkV1(sl.MerkleRoot(), 1<<12, 1<<12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, skylink := range []Skylink{v2, offset} {
+		_, err = client.DownloadVerified(skylink.String(), opts)
+		if !errors.Contains(err, ErrVerificationUnsupported) {
+			t.Fatalf("expected error %v, got %v", ErrVerificationUnsupported, err)
+		}
+	}
+}
@@ -2,10 +2,16 @@ package skynet
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/url"
+	"strings"
 
 	"gitlab.com/NebulousLabs/errors"
+	"golang.org/x/crypto/blake2b"
 )
 
 type (
@@ -33,6 +39,23 @@ type (
 	GetSkykeysOptions struct {
 		Options
 	}
+	// DeleteSkykeyOptions contains the options used for deleteskykey.
+	DeleteSkykeyOptions struct {
+		Options
+	}
+
+	// DecodedSkykey contains the fields of a skykey string.
+	DecodedSkykey struct {
+		// Name is the name of the skykey, which may be empty.
+		Name string
+		// Type is the type of the skykey, either SkykeyTypePublicID or
+		// SkykeyTypePrivateID.
+		Type string
+		// ID is the base64 encoded ID of the skykey, as returned by portals.
+		ID string
+		// Entropy is the key followed by the nonce of the skykey.
+		Entropy []byte
+	}
 
 	// CreateSkykeyResponse contains the response for creating a skykey.
 	CreateSkykeyResponse Skykey
@@ -45,6 +68,27 @@ type (
 	}
 )
 
+const (
+	// SkykeyTypePublicID is the type of skykeys whose ID is revealed in the
+	// skyfiles encrypted with them.
+	SkykeyTypePublicID = "public-id"
+	// SkykeyTypePrivateID is the type of skykeys whose ID isn't revealed in
+	// the skyfiles encrypted with them.
+	SkykeyTypePrivateID = "private-id"
+
+	// skykeyScheme is the URI scheme of skykey strings.
+	skykeyScheme = "skykey"
+	// skykeyEntropySize is the size of the entropy of a skykey, an
+	// XChaCha20 key followed by a nonce.
+	skykeyEntropySize = 32 + 24
+	// skykeyKeySize is the size of the key in the entropy of a skykey.
+	skykeyKeySize = 32
+	// skykeyIDSize is the size of a skykey ID.
+	skykeyIDSize = 16
+	// maxSkykeyNameSize is the maximum size of the name of a skykey.
+	maxSkykeyNameSize = 128
+)
+
 var (
 	// DefaultAddSkykeyOptions contains the default addskykey options.
 	DefaultAddSkykeyOptions = AddSkykeyOptions{
@@ -62,10 +106,33 @@ var (
 	DefaultGetSkykeysOptions = GetSkykeysOptions{
 		Options: DefaultOptions("/skynet/skykeys"),
 	}
+	// DefaultDeleteSkykeyOptions contains the default deleteskykey options.
+	DefaultDeleteSkykeyOptions = DeleteSkykeyOptions{
+		Options: DefaultOptions("/skynet/deleteskykey"),
+	}
+
+	// ErrInvalidSkykey is returned when a skykey string could not be decoded.
+	ErrInvalidSkykey = errors.New("invalid skykey")
+
+	// skykeyTypes maps the type bytes of skykeys to their names.
+	skykeyTypes = map[byte]string{
+		0x01: SkykeyTypePublicID,
+		0x02: SkykeyTypePrivateID,
+	}
+
+	// skykeySpecifier is hashed along with skykeys to compute their IDs.
+	skykeySpecifier = [16]byte{'S', 'k', 'y', 'k', 'e', 'y'}
 )
 
 // AddSkykey stores the given base-64 encoded skykey with the skykey manager.
+// Use DecodeSkykey to validate the skykey before adding it.
 func (sc *SkynetClient) AddSkykey(skykey string, opts AddSkykeyOptions) error {
+	return sc.AddSkykeyWithContext(context.Background(), skykey, opts)
+}
+
+// AddSkykeyWithContext stores the given base-64 encoded skykey with the skykey
+// manager.
+func (sc *SkynetClient) AddSkykeyWithContext(ctx context.Context, skykey string, opts AddSkykeyOptions) error {
 	body := &bytes.Buffer{}
 	values := url.Values{}
 	values.Set("skykey", skykey)
@@ -73,6 +140,7 @@ func (sc *SkynetClient) AddSkykey(skykey string, opts AddSkykeyOptions) error {
 	_, err := sc.executeRequest(
 		requestOptions{
 			Options: opts.Options,
+			ctx:     ctx,
 			method:  "POST",
 			reqBody: body,
 			query:   values,
@@ -88,6 +156,12 @@ func (sc *SkynetClient) AddSkykey(skykey string, opts AddSkykeyOptions) error {
 // CreateSkykey returns a new skykey created and stored under the given name
 // with the given type. skykeyType can be either "public-id" or "private-id".
 func (sc *SkynetClient) CreateSkykey(name, skykeyType string, opts CreateSkykeyOptions) (Skykey, error) {
+	return sc.CreateSkykeyWithContext(context.Background(), name, skykeyType, opts)
+}
+
+// CreateSkykeyWithContext returns a new skykey created and stored under the
+// given name with the given type.
+func (sc *SkynetClient) CreateSkykeyWithContext(ctx context.Context, name, skykeyType string, opts CreateSkykeyOptions) (Skykey, error) {
 	body := &bytes.Buffer{}
 	values := url.Values{}
 	values.Set("name", name)
@@ -96,6 +170,7 @@ func (sc *SkynetClient) CreateSkykey(name, skykeyType string, opts CreateSkykeyO
 	resp, err := sc.executeRequest(
 		requestOptions{
 			Options: opts.Options,
+			ctx:     ctx,
 			method:  "POST",
 			reqBody: body,
 			query:   values,
@@ -121,6 +196,11 @@ func (sc *SkynetClient) CreateSkykey(name, skykeyType string, opts CreateSkykeyO
 
 // GetSkykeyByName returns the given skykey given its name.
 func (sc *SkynetClient) GetSkykeyByName(name string, opts GetSkykeyOptions) (Skykey, error) {
+	return sc.GetSkykeyByNameWithContext(context.Background(), name, opts)
+}
+
+// GetSkykeyByNameWithContext returns the given skykey given its name.
+func (sc *SkynetClient) GetSkykeyByNameWithContext(ctx context.Context, name string, opts GetSkykeyOptions) (Skykey, error) {
 	body := &bytes.Buffer{}
 	values := url.Values{}
 	values.Set("name", name)
@@ -128,6 +208,7 @@ func (sc *SkynetClient) GetSkykeyByName(name string, opts GetSkykeyOptions) (Sky
 	resp, err := sc.executeRequest(
 		requestOptions{
 			Options: opts.Options,
+			ctx:     ctx,
 			method:  "GET",
 			reqBody: body,
 			query:   values,
@@ -153,6 +234,11 @@ func (sc *SkynetClient) GetSkykeyByName(name string, opts GetSkykeyOptions) (Sky
 
 // GetSkykeyByID returns the given skykey given its ID.
 func (sc *SkynetClient) GetSkykeyByID(id string, opts GetSkykeyOptions) (Skykey, error) {
+	return sc.GetSkykeyByIDWithContext(context.Background(), id, opts)
+}
+
+// GetSkykeyByIDWithContext returns the given skykey given its ID.
+func (sc *SkynetClient) GetSkykeyByIDWithContext(ctx context.Context, id string, opts GetSkykeyOptions) (Skykey, error) {
 	body := &bytes.Buffer{}
 	values := url.Values{}
 	values.Set("id", id)
@@ -160,6 +246,7 @@ func (sc *SkynetClient) GetSkykeyByID(id string, opts GetSkykeyOptions) (Skykey,
 	resp, err := sc.executeRequest(
 		requestOptions{
 			Options: opts.Options,
+			ctx:     ctx,
 			method:  "GET",
 			reqBody: body,
 			query:   values,
@@ -185,9 +272,15 @@ func (sc *SkynetClient) GetSkykeyByID(id string, opts GetSkykeyOptions) (Skykey,
 
 // GetSkykeys returns a list of all skykeys.
 func (sc *SkynetClient) GetSkykeys(opts GetSkykeysOptions) ([]Skykey, error) {
+	return sc.GetSkykeysWithContext(context.Background(), opts)
+}
+
+// GetSkykeysWithContext returns a list of all skykeys.
+func (sc *SkynetClient) GetSkykeysWithContext(ctx context.Context, opts GetSkykeysOptions) ([]Skykey, error) {
 	resp, err := sc.executeRequest(
 		requestOptions{
 			Options: opts.Options,
+			ctx:     ctx,
 			method:  "GET",
 			reqBody: &bytes.Buffer{},
 		},
@@ -209,3 +302,164 @@ func (sc *SkynetClient) GetSkykeys(opts GetSkykeysOptions) ([]Skykey, error) {
 
 	return apiResponse.Skykeys, nil
 }
+
+// DeleteSkykeyByName deletes the skykey with the given name.
+func (sc *SkynetClient) DeleteSkykeyByName(name string, opts DeleteSkykeyOptions) error {
+	return sc.DeleteSkykeyByNameWithContext(context.Background(), name, opts)
+}
+
+// DeleteSkykeyByNameWithContext deletes the skykey with the given name.
+func (sc *SkynetClient) DeleteSkykeyByNameWithContext(ctx context.Context, name string, opts DeleteSkykeyOptions) error {
+	body := &bytes.Buffer{}
+	values := url.Values{}
+	values.Set("name", name)
+
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options: opts.Options,
+			ctx:     ctx,
+			method:  "POST",
+			reqBody: body,
+			query:   values,
+		},
+	)
+	if err != nil {
+		return errors.AddContext(err, "could not execute request")
+	}
+
+	return resp.Body.Close()
+}
+
+// DeleteSkykeyByID deletes the skykey with the given ID.
+func (sc *SkynetClient) DeleteSkykeyByID(id string, opts DeleteSkykeyOptions) error {
+	return sc.DeleteSkykeyByIDWithContext(context.Background(), id, opts)
+}
+
+// DeleteSkykeyByIDWithContext deletes the skykey with the given ID.
+func (sc *SkynetClient) DeleteSkykeyByIDWithContext(ctx context.Context, id string, opts DeleteSkykeyOptions) error {
+	body := &bytes.Buffer{}
+	values := url.Values{}
+	values.Set("id", id)
+
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options: opts.Options,
+			ctx:     ctx,
+			method:  "POST",
+			reqBody: body,
+			query:   values,
+		},
+	)
+	if err != nil {
+		return errors.AddContext(err, "could not execute request")
+	}
+
+	return resp.Body.Close()
+}
+
+// DecodeSkykey decodes a skykey string, as returned by portals, without
+// contacting a portal. The string has the form
+// "skykey:<base64 key data>?name=<name>", where the scheme and name are
+// optional.
+func DecodeSkykey(skykey string) (DecodedSkykey, error) {
+	u, err := url.Parse(skykey)
+	if err != nil {
+		return DecodedSkykey{}, errors.Compose(ErrInvalidSkykey, err)
+	}
+	var data string
+	switch u.Scheme {
+	case skykeyScheme:
+		data = u.Opaque
+	case "":
+		data = u.Path
+	default:
+		return DecodedSkykey{}, errors.AddContext(ErrInvalidSkykey, fmt.Sprintf("unknown scheme %v", u.Scheme))
+	}
+	name := u.Query().Get("name")
+	if len(name) > maxSkykeyNameSize {
+		return DecodedSkykey{}, errors.AddContext(ErrInvalidSkykey, "name is too long")
+	}
+
+	// The data is the type followed by the entropy.
+	keyData, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "="))
+	if err != nil {
+		return DecodedSkykey{}, errors.Compose(ErrInvalidSkykey, err)
+	}
+	if len(keyData) != 1+skykeyEntropySize {
+		return DecodedSkykey{}, errors.AddContext(ErrInvalidSkykey, fmt.Sprintf("key data has incorrect size %v", len(keyData)))
+	}
+	skykeyType, ok := skykeyTypes[keyData[0]]
+	if !ok {
+		return DecodedSkykey{}, errors.AddContext(ErrInvalidSkykey, fmt.Sprintf("unsupported type %v", keyData[0]))
+	}
+	entropy := keyData[1:]
+
+	return DecodedSkykey{
+		Name:    name,
+		Type:    skykeyType,
+		ID:      skykeyID(keyData[0], entropy),
+		Entropy: entropy,
+	}, nil
+}
+
+// GenSkykey generates a skykey with the given name and type locally, in the
+// same way as skyd does. The skykey can be added to a portal with AddSkykey
+// using its String.
+func GenSkykey(name, skykeyType string) (DecodedSkykey, error) {
+	if len(name) > maxSkykeyNameSize {
+		return DecodedSkykey{}, errors.AddContext(ErrInvalidSkykey, "name is too long")
+	}
+	typeByte, ok := skykeyTypeByte(skykeyType)
+	if !ok {
+		return DecodedSkykey{}, errors.AddContext(ErrInvalidSkykey, fmt.Sprintf("unsupported type %v", skykeyType))
+	}
+	entropy := make([]byte, skykeyEntropySize)
+	_, err := rand.Read(entropy)
+	if err != nil {
+		return DecodedSkykey{}, errors.AddContext(err, "could not generate entropy")
+	}
+	return DecodedSkykey{
+		Name:    name,
+		Type:    skykeyType,
+		ID:      skykeyID(typeByte, entropy),
+		Entropy: entropy,
+	}, nil
+}
+
+// String returns the skykey string of the skykey, in the format used by
+// portals.
+func (sk DecodedSkykey) String() string {
+	typeByte, _ := skykeyTypeByte(sk.Type)
+	u := url.URL{
+		Scheme: skykeyScheme,
+		Opaque: base64.URLEncoding.EncodeToString(append([]byte{typeByte}, sk.Entropy...)),
+	}
+	if sk.Name != "" {
+		// Escape the name so that it is decoded correctly.
+		u.RawQuery = url.Values{"name": {sk.Name}}.Encode()
+	}
+	return u.String()
+}
+
+// skykeyTypeByte returns the type byte of the skykey type with the given name.
+func skykeyTypeByte(skykeyType string) (byte, bool) {
+	for b, t := range skykeyTypes {
+		if t == skykeyType {
+			return b, true
+		}
+	}
+	return 0, false
+}
+
+// skykeyID returns the base64 encoded ID of the skykey with the given type and
+// entropy. The ID only depends on the key and not the nonce of the entropy.
+func skykeyID(skykeyType byte, entropy []byte) string {
+	// The ID is the start of the hash of the Sia encoding of the specifier,
+	// the type and the key.
+	b := append([]byte(nil), skykeySpecifier[:]...)
+	b = appendUint64(b, uint64(skykeyType))
+	b = appendUint64(b, skykeyKeySize)
+	b = append(b, entropy[:skykeyKeySize]...)
+	hash := blake2b.Sum256(b)
+	return base64.URLEncoding.EncodeToString(hash[:skykeyIDSize])
+}
@@ -0,0 +1,111 @@
+package skynet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestDecodeSkykey tests decoding skykey strings created by skyd.
+func TestDecodeSkykey(t *testing.T) {
+	const skykey = "skykey:AbAc7Uz4NxBrVIzR2lY-LsVs3VWsuCA0D01jxYjaHdRwrfVUuo8DutiGD7OF1B1b3P1olWPXZO1X?name=hardcodedtestkey"
+	const entropy = "b01ced4cf837106b548cd1da563e2ec56cdd55acb820340f4d63c588da1dd470adf554ba8f03bad8860fb385d41d5bdcfd689563d764ed57"
+
+	decoded, err := DecodeSkykey(skykey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Name != "hardcodedtestkey" {
+		t.Fatalf("expected name %v, got %v", "hardcodedtestkey", decoded.Name)
+	}
+	if decoded.Type != SkykeyTypePublicID {
+		t.Fatalf("expected type %v, got %v", SkykeyTypePublicID, decoded.Type)
+	}
+	if decoded.ID != "GLimqYbBtDplrx4mu71zbg==" {
+		t.Fatalf("expected ID %v, got %v", "GLimqYbBtDplrx4mu71zbg==", decoded.ID)
+	}
+	if hex.EncodeToString(decoded.Entropy) != entropy {
+		t.Fatalf("expected entropy %v, got %x", entropy, decoded.Entropy)
+	}
+
+	// The scheme and name are optional.
+	decoded2, err := DecodeSkykey("AbAc7Uz4NxBrVIzR2lY-LsVs3VWsuCA0D01jxYjaHdRwrfVUuo8DutiGD7OF1B1b3P1olWPXZO1X")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded2.Name != "" || decoded2.ID != decoded.ID {
+		t.Fatalf("unexpected skykey %+v", decoded2)
+	}
+
+	// Test invalid skykeys.
+	tests := []string{
+		"",
+		"skykey:AbAc7Uz4",
+		"skykey:A7Ac7Uz4NxBrVIzR2lY-LsVs3VWsuCA0D01jxYjaHdRwrfVUuo8DutiGD7OF1B1b3P1olWPXZO1X",
+		"skykey:AbAc7Uz4NxBrVIzR2lY-LsVs3VWsuCA0D01jxYjaHdRwrfVUuo8DutiGD7OF1B1b3P1olWPXZO1X!",
+		"other:AbAc7Uz4NxBrVIzR2lY-LsVs3VWsuCA0D01jxYjaHdRwrfVUuo8DutiGD7OF1B1b3P1olWPXZO1X",
+		"skykey:BAAAAAAAAABrZXkxAAAAAAAAAAQgAAAAAAAAADiObVg49-0juJ8udAx4qMW-TEHgDxfjA0fjJSNBuJ4a",
+	}
+	for _, test := range tests {
+		_, err := DecodeSkykey(test)
+		if !errors.Contains(err, ErrInvalidSkykey) {
+			t.Fatalf("%q: expected error %v, got %v", test, ErrInvalidSkykey, err)
+		}
+	}
+}
+
+// TestGenSkykey tests generating skykeys and encoding them as skykey strings.
+func TestGenSkykey(t *testing.T) {
+	// Encoding a decoded skykey should result in the same string.
+	const skykey = "skykey:AbAc7Uz4NxBrVIzR2lY-LsVs3VWsuCA0D01jxYjaHdRwrfVUuo8DutiGD7OF1B1b3P1olWPXZO1X?name=hardcodedtestkey"
+	decoded, err := DecodeSkykey(skykey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.String() != skykey {
+		t.Fatalf("expected %v, got %v", skykey, decoded.String())
+	}
+
+	for _, skykeyType := range []string{SkykeyTypePublicID, SkykeyTypePrivateID} {
+		sk, err := GenSkykey("key", skykeyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeSkykey(sk.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Name != "key" || decoded.Type != skykeyType || decoded.ID != sk.ID || !bytes.Equal(decoded.Entropy, sk.Entropy) {
+			t.Fatalf("expected %+v, got %+v", sk, decoded)
+		}
+	}
+
+	// Names with characters that have a meaning in URLs should be
+	// preserved.
+	for _, name := range []string{"a+b", "a&b", "a#b", "a b", "100%", "x%zz", "name=x"} {
+		sk, err := GenSkykey(name, SkykeyTypePublicID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeSkykey(sk.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Name != name || decoded.ID != sk.ID {
+			t.Fatalf("expected name %q and ID %v, got %q and %v", name, sk.ID, decoded.Name, decoded.ID)
+		}
+	}
+
+	// Test invalid names and types.
+	_, err = GenSkykey(strings.Repeat("a", maxSkykeyNameSize+1), SkykeyTypePublicID)
+	if !errors.Contains(err, ErrInvalidSkykey) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidSkykey, err)
+	}
+	_, err = GenSkykey("key", "unknown")
+	if !errors.Contains(err, ErrInvalidSkykey) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidSkykey, err)
+	}
+}
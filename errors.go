@@ -0,0 +1,119 @@
+package skynet
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// ResponseError is the error returned for a response with a status code
+	// >= 400. Errors returned by the client are composed with additional
+	// context using the errors package, so use errors.Contains to check them
+	// for ErrResponseError and AsResponseError or one of the IsX helpers to
+	// inspect them. errors.As of the standard library can't look into
+	// composed errors.
+	ResponseError struct {
+		// StatusCode is the status code of the response.
+		StatusCode int
+		// Method is the method of the request.
+		Method string
+		// URL is the URL of the request, with any secrets redacted.
+		URL string
+		// Message is the error message returned by the portal.
+		Message string
+		// Header contains the headers of the response.
+		Header http.Header
+	}
+)
+
+var (
+	// redactedQueryParams are the query parameters whose values are redacted
+	// from URLs in errors.
+	redactedQueryParams = []string{"apikey", "skykey", "token", "password"}
+)
+
+// Error implements the error interface.
+func (re *ResponseError) Error() string {
+	return fmt.Sprintf("%v response from %v %v: %v", re.StatusCode, re.Method, re.URL, re.Message)
+}
+
+// Is returns true for ErrResponseError so that ResponseErrors match it.
+func (re *ResponseError) Is(target error) bool {
+	return target == ErrResponseError
+}
+
+// AsResponseError returns the ResponseError contained in the given error, if
+// any.
+func AsResponseError(err error) (*ResponseError, bool) {
+	switch v := err.(type) {
+	case nil:
+		return nil, false
+	case *ResponseError:
+		return v, true
+	case errors.Error:
+		for _, e := range v.ErrSet {
+			if re, ok := AsResponseError(e); ok {
+				return re, true
+			}
+		}
+		return nil, false
+	case interface{ Unwrap() error }:
+		return AsResponseError(v.Unwrap())
+	default:
+		return nil, false
+	}
+}
+
+// IsNotFound returns true if the error was caused by a 404 response, e.g. for
+// a skylink that could not be found.
+func IsNotFound(err error) bool {
+	return hasStatusCode(err, http.StatusNotFound)
+}
+
+// IsRateLimited returns true if the error was caused by a 429 response.
+func IsRateLimited(err error) bool {
+	return hasStatusCode(err, http.StatusTooManyRequests)
+}
+
+// IsUnauthorized returns true if the error was caused by a 401 response, e.g.
+// for a missing or invalid API key.
+func IsUnauthorized(err error) bool {
+	return hasStatusCode(err, http.StatusUnauthorized)
+}
+
+// hasStatusCode returns true if the error contains a ResponseError with the
+// given status code.
+func hasStatusCode(err error, statusCode int) bool {
+	re, ok := AsResponseError(err)
+	return ok && re.StatusCode == statusCode
+}
+
+// redactURL returns the given URL with any user password and the values of
+// sensitive query parameters redacted.
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	redacted := *u
+	if _, hasPassword := redacted.User.Password(); hasPassword {
+		redacted.User = url.UserPassword(redacted.User.Username(), "REDACTED")
+	}
+	query := redacted.Query()
+	changed := false
+	for key := range query {
+		for _, param := range redactedQueryParams {
+			if strings.EqualFold(key, param) {
+				query.Set(key, "REDACTED")
+				changed = true
+			}
+		}
+	}
+	if changed {
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.String()
+}
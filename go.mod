@@ -3,6 +3,8 @@ module github.com/SkynetLabs/go-skynet/v2
 go 1.13
 
 require (
+	github.com/gorilla/websocket v1.4.2
 	gitlab.com/NebulousLabs/errors v0.0.0-20171229012116-7ead97ef90b8
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	gopkg.in/h2non/gock.v1 v1.0.15
 )
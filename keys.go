@@ -0,0 +1,152 @@
+package skynet
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+type (
+	// KeyPair is an ed25519 key pair used to sign registry entries.
+	KeyPair struct {
+		PublicKey  ed25519.PublicKey
+		PrivateKey ed25519.PrivateKey
+	}
+
+	// KeyPairAndSeed is a key pair along with the seed it was derived from.
+	KeyPairAndSeed struct {
+		KeyPair
+		// Seed is the seed the key pair was derived from.
+		Seed string
+	}
+)
+
+const (
+	// PublicKeyPrefix is the prefix of the public keys used by portals, which
+	// is followed by the hex encoded key.
+	PublicKeyPrefix = "ed25519:"
+
+	// seedSize is the number of random bytes in generated seeds.
+	seedSize = 64
+
+	// keyDerivationIterations is the number of PBKDF2 iterations used to
+	// derive keys from seeds.
+	keyDerivationIterations = 1000
+)
+
+var (
+	// ErrInvalidPublicKey is returned when a public key could not be parsed.
+	ErrInvalidPublicKey = errors.New("invalid public key")
+	// ErrInvalidPrivateKey is returned when a private key could not be
+	// parsed.
+	ErrInvalidPrivateKey = errors.New("invalid private key")
+)
+
+// GenKeyPairFromSeed derives a key pair from the given seed. The same seed
+// always results in the same key pair, which is also the key pair the other
+// Skynet SDKs derive from it.
+func GenKeyPairFromSeed(seed string) KeyPair {
+	// The seed of the ed25519 key is derived with PBKDF2-HMAC-SHA256 and an
+	// empty salt.
+	derivedKey := pbkdf2.Key([]byte(seed), nil, keyDerivationIterations, ed25519.SeedSize, sha256.New)
+	privateKey := ed25519.NewKeyFromSeed(derivedKey)
+	return KeyPair{
+		PublicKey:  privateKey.Public().(ed25519.PublicKey),
+		PrivateKey: privateKey,
+	}
+}
+
+// GenKeyPairAndSeed generates a random seed and derives a key pair from it.
+// Store the seed to recreate the key pair with GenKeyPairFromSeed.
+func GenKeyPairAndSeed() (KeyPairAndSeed, error) {
+	seed := make([]byte, seedSize)
+	_, err := rand.Read(seed)
+	if err != nil {
+		return KeyPairAndSeed{}, errors.AddContext(err, "could not generate seed")
+	}
+	seedHex := hex.EncodeToString(seed)
+	return KeyPairAndSeed{
+		KeyPair: GenKeyPairFromSeed(seedHex),
+		Seed:    seedHex,
+	}, nil
+}
+
+// FormatPublicKey returns the public key in the form used by portals,
+// "ed25519:" followed by the hex encoded key.
+func FormatPublicKey(publicKey ed25519.PublicKey) string {
+	return PublicKeyPrefix + hex.EncodeToString(publicKey)
+}
+
+// FormatPublicKeyBase64 returns the URL-safe base64 encoding of the public key.
+func FormatPublicKeyBase64(publicKey ed25519.PublicKey) string {
+	return base64.RawURLEncoding.EncodeToString(publicKey)
+}
+
+// ParsePublicKey parses a public key in the form used by portals, or a hex or
+// base64 encoded public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	if strings.HasPrefix(s, PublicKeyPrefix) {
+		key, err := decodeKey(strings.TrimPrefix(s, PublicKeyPrefix), ed25519.PublicKeySize, false)
+		if err != nil {
+			return nil, errors.Compose(ErrInvalidPublicKey, err)
+		}
+		return ed25519.PublicKey(key), nil
+	}
+	key, err := decodeKey(s, ed25519.PublicKeySize, true)
+	if err != nil {
+		return nil, errors.Compose(ErrInvalidPublicKey, err)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// ParsePrivateKey parses a hex or base64 encoded private key, which contains
+// the seed of the key followed by the public key.
+func ParsePrivateKey(s string) (ed25519.PrivateKey, error) {
+	key, err := decodeKey(s, ed25519.PrivateKeySize, true)
+	if err != nil {
+		return nil, errors.Compose(ErrInvalidPrivateKey, err)
+	}
+	privateKey := ed25519.PrivateKey(key)
+	// Make sure the public key matches the seed, as the public key in the
+	// private key is used for signing.
+	expected := ed25519.NewKeyFromSeed(privateKey.Seed())
+	if !bytes.Equal(privateKey, expected) {
+		return nil, errors.Compose(ErrInvalidPrivateKey, errors.New("public key doesn't match the seed"))
+	}
+	return privateKey, nil
+}
+
+// decodeKey decodes a hex, or if allowed, a base64 encoded key of the given
+// size.
+func decodeKey(s string, size int, allowBase64 bool) ([]byte, error) {
+	if len(s) == hex.EncodedLen(size) {
+		key, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, errors.AddContext(err, "could not decode hex")
+		}
+		return key, nil
+	}
+	if !allowBase64 {
+		return nil, fmt.Errorf("expected %v hex characters, got %v", hex.EncodedLen(size), len(s))
+	}
+	// Accept both the standard and URL-safe encodings, with and without
+	// padding.
+	s = strings.TrimRight(s, "=")
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(s)
+	if len(s) != base64.RawURLEncoding.EncodedLen(size) {
+		return nil, fmt.Errorf("key has incorrect length %v", len(s))
+	}
+	key, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not decode base64")
+	}
+	return key, nil
+}
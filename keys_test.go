@@ -0,0 +1,124 @@
+package skynet
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestGenKeyPairFromSeed tests deriving key pairs against the keys the other
+// Skynet SDKs derive from the same seeds.
+func TestGenKeyPairFromSeed(t *testing.T) {
+	tests := []struct {
+		seed, publicKey, privateKey string
+	}{
+		{
+			seed:       "c1197e1275fbf570d21dde01a00af83ed4a743d1884e4a09cebce0dd21ae254c",
+			publicKey:  "f8a7da8324fabb9d57bb32c59c48d4ba304d08ee5f1297a46836cf841da71c80",
+			privateKey: "c404ff07fba961000dfb25ece7477f45b109b50a5169a45f3fb239343002c1cff8a7da8324fabb9d57bb32c59c48d4ba304d08ee5f1297a46836cf841da71c80",
+		},
+	}
+	for _, test := range tests {
+		keyPair := GenKeyPairFromSeed(test.seed)
+		if hex.EncodeToString(keyPair.PublicKey) != test.publicKey {
+			t.Fatalf("%v: expected public key %v, got %x", test.seed, test.publicKey, keyPair.PublicKey)
+		}
+		if hex.EncodeToString(keyPair.PrivateKey) != test.privateKey {
+			t.Fatalf("%v: expected private key %v, got %x", test.seed, test.privateKey, keyPair.PrivateKey)
+		}
+	}
+}
+
+// TestGenKeyPairAndSeed tests that generated key pairs can be recreated from
+// their seeds.
+func TestGenKeyPairAndSeed(t *testing.T) {
+	keyPairAndSeed, err := GenKeyPairAndSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyPairAndSeed.Seed) != 2*seedSize {
+		t.Fatalf("expected seed of length %v, got %v", 2*seedSize, len(keyPairAndSeed.Seed))
+	}
+	keyPair := GenKeyPairFromSeed(keyPairAndSeed.Seed)
+	if !bytes.Equal(keyPair.PrivateKey, keyPairAndSeed.PrivateKey) || !bytes.Equal(keyPair.PublicKey, keyPairAndSeed.PublicKey) {
+		t.Fatal("key pair doesn't match the seed")
+	}
+	keyPairAndSeed2, err := GenKeyPairAndSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyPairAndSeed2.Seed == keyPairAndSeed.Seed {
+		t.Fatal("expected different seeds")
+	}
+}
+
+// TestParsePublicKey tests formatting and parsing public keys.
+func TestParsePublicKey(t *testing.T) {
+	publicKey := GenKeyPairFromSeed("seed").PublicKey
+
+	formatted := FormatPublicKey(publicKey)
+	if formatted != "ed25519:"+hex.EncodeToString(publicKey) {
+		t.Fatalf("unexpected formatted public key %v", formatted)
+	}
+	for _, s := range []string{
+		formatted,
+		hex.EncodeToString(publicKey),
+		FormatPublicKeyBase64(publicKey),
+		base64.StdEncoding.EncodeToString(publicKey),
+		base64.URLEncoding.EncodeToString(publicKey),
+	} {
+		parsed, err := ParsePublicKey(s)
+		if err != nil {
+			t.Fatalf("%v: %v", s, err)
+		}
+		if !bytes.Equal(parsed, publicKey) {
+			t.Fatalf("%v: expected public key %x, got %x", s, publicKey, parsed)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"ed25519:",
+		formatted[:len(formatted)-2],
+		"ed25519:" + FormatPublicKeyBase64(publicKey),
+		formatted[:len(formatted)-1] + "x",
+		FormatPublicKeyBase64(publicKey)[1:] + "!",
+	} {
+		_, err := ParsePublicKey(s)
+		if !errors.Contains(err, ErrInvalidPublicKey) {
+			t.Fatalf("%q: expected error %v, got %v", s, ErrInvalidPublicKey, err)
+		}
+	}
+}
+
+// TestParsePrivateKey tests parsing private keys.
+func TestParsePrivateKey(t *testing.T) {
+	privateKey := GenKeyPairFromSeed("seed").PrivateKey
+
+	for _, s := range []string{
+		hex.EncodeToString(privateKey),
+		base64.StdEncoding.EncodeToString(privateKey),
+	} {
+		parsed, err := ParsePrivateKey(s)
+		if err != nil {
+			t.Fatalf("%v: %v", s, err)
+		}
+		if !bytes.Equal(parsed, privateKey) {
+			t.Fatalf("%v: expected private key %x, got %x", s, privateKey, parsed)
+		}
+	}
+
+	// The public key has to match the seed.
+	invalid := append(ed25519.PrivateKey{}, privateKey...)
+	invalid[ed25519.SeedSize] ^= 1
+	for _, s := range []string{"", hex.EncodeToString(privateKey[:ed25519.SeedSize]), hex.EncodeToString(invalid)} {
+		_, err := ParsePrivateKey(s)
+		if !errors.Contains(err, ErrInvalidPrivateKey) {
+			t.Fatalf("%q: expected error %v, got %v", s, ErrInvalidPrivateKey, err)
+		}
+	}
+}
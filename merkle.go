@@ -0,0 +1,76 @@
+package skynet
+
+import (
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// merkleSegmentSize is the size of the leaves of the merkle tree used by
+	// Sia.
+	merkleSegmentSize = 64
+)
+
+var (
+	// leafHashPrefix is the prefix of the data hashed for leaves.
+	leafHashPrefix = []byte{0}
+	// nodeHashPrefix is the prefix of the data hashed for nodes.
+	nodeHashPrefix = []byte{1}
+)
+
+type (
+	// merkleSubtree is the root of a perfect subtree of the given height.
+	merkleSubtree struct {
+		height int
+		root   [32]byte
+	}
+)
+
+// merkleRoot returns the Sia merkle root of the data. The data is split into
+// 64 byte leaves, with the last leaf being shorter if necessary.
+func merkleRoot(data []byte) [32]byte {
+	// Build perfect subtrees from left to right, merging subtrees of equal
+	// height as soon as possible.
+	var stack []merkleSubtree
+	for {
+		n := merkleSegmentSize
+		if len(data) < n {
+			n = len(data)
+		}
+		stack = append(stack, merkleSubtree{root: merkleLeafHash(data[:n])})
+		data = data[n:]
+		for len(stack) > 1 && stack[len(stack)-1].height == stack[len(stack)-2].height {
+			left, right := stack[len(stack)-2], stack[len(stack)-1]
+			stack = append(stack[:len(stack)-2], merkleSubtree{
+				height: left.height + 1,
+				root:   merkleNodeHash(left.root, right.root),
+			})
+		}
+		if len(data) == 0 {
+			break
+		}
+	}
+
+	// Join the remaining subtrees from right to left.
+	root := stack[len(stack)-1].root
+	for i := len(stack) - 2; i >= 0; i-- {
+		root = merkleNodeHash(stack[i].root, root)
+	}
+	return root
+}
+
+// merkleLeafHash returns the hash of a leaf with the given data.
+func merkleLeafHash(leaf []byte) [32]byte {
+	data := make([]byte, 0, len(leafHashPrefix)+len(leaf))
+	data = append(data, leafHashPrefix...)
+	data = append(data, leaf...)
+	return blake2b.Sum256(data)
+}
+
+// merkleNodeHash returns the hash of a node with the given children.
+func merkleNodeHash(left, right [32]byte) [32]byte {
+	data := make([]byte, 0, len(nodeHashPrefix)+len(left)+len(right))
+	data = append(data, nodeHashPrefix...)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+	return blake2b.Sum256(data)
+}
@@ -0,0 +1,46 @@
+package skynet
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// TestMerkleRoot tests computing merkle roots of balanced and unbalanced
+// trees.
+func TestMerkleRoot(t *testing.T) {
+	leafHash := func(leaf []byte) [32]byte {
+		return blake2b.Sum256(append([]byte{0}, leaf...))
+	}
+	nodeHash := func(left, right [32]byte) [32]byte {
+		return blake2b.Sum256(append(append([]byte{1}, left[:]...), right[:]...))
+	}
+	leaves := [][]byte{
+		bytes.Repeat([]byte{1}, merkleSegmentSize),
+		bytes.Repeat([]byte{2}, merkleSegmentSize),
+		bytes.Repeat([]byte{3}, merkleSegmentSize),
+		{4, 4, 4},
+	}
+	h0, h1, h2, h3 := leafHash(leaves[0]), leafHash(leaves[1]), leafHash(leaves[2]), leafHash(leaves[3])
+
+	tests := []struct {
+		data []byte
+		root [32]byte
+	}{
+		// A single leaf, possibly shorter than a segment.
+		{leaves[0], h0},
+		{leaves[3], h3},
+		// A balanced tree.
+		{bytes.Join(leaves[:2], nil), nodeHash(h0, h1)},
+		{bytes.Join(leaves, nil), nodeHash(nodeHash(h0, h1), nodeHash(h2, h3))},
+		// Unbalanced trees have the largest perfect subtree on the left.
+		{bytes.Join(leaves[:3], nil), nodeHash(nodeHash(h0, h1), h2)},
+		{bytes.Join([][]byte{leaves[0], leaves[1], leaves[2], leaves[0], leaves[1]}, nil), nodeHash(nodeHash(nodeHash(h0, h1), nodeHash(h2, h0)), h1)},
+	}
+	for i, test := range tests {
+		if root := merkleRoot(test.data); root != test.root {
+			t.Fatalf("test %v: expected root %x, got %x", i, test.root, root)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package skynet
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+type (
+	// UploadProgress contains the progress of an upload. Sizes are -1 if they
+	// are not known up front.
+	UploadProgress struct {
+		// Filename is the name of the file currently being sent.
+		Filename string
+		// FileBytes is the number of bytes of the current file sent so far.
+		FileBytes int64
+		// FileSize is the size of the current file.
+		FileSize int64
+		// TotalBytes is the number of bytes of all files sent so far.
+		TotalBytes int64
+		// TotalSize is the combined size of all files.
+		TotalSize int64
+	}
+
+	// UploadProgressFunc is called with the progress of an upload.
+	UploadProgressFunc func(UploadProgress)
+
+	// DownloadProgress contains the progress of a download.
+	DownloadProgress struct {
+		// BytesReceived is the number of bytes received so far.
+		BytesReceived int64
+		// ContentLength is the length of the content advertised by the
+		// portal, or -1 if it is unknown.
+		ContentLength int64
+	}
+
+	// DownloadProgressFunc is called with the progress of a download.
+	DownloadProgressFunc func(DownloadProgress)
+
+	// progressThrottle limits how often progress is reported.
+	progressThrottle struct {
+		interval time.Duration
+		last     time.Time
+	}
+
+	// downloadProgressReader is an io.ReadCloser that counts the bytes read
+	// from a download and reports the progress.
+	downloadProgressReader struct {
+		r        io.ReadCloser
+		fn       DownloadProgressFunc
+		progress DownloadProgress
+		throttle *progressThrottle
+		done     bool
+	}
+
+	// uploadProgressWriter is an io.Writer that counts the bytes of a file
+	// written to the upload body and reports the progress.
+	uploadProgressWriter struct {
+		w        io.Writer
+		fn       UploadProgressFunc
+		progress *UploadProgress
+		throttle *progressThrottle
+	}
+)
+
+const (
+	// DefaultProgressInterval is the minimum time between progress reports if
+	// no interval is set in the options.
+	DefaultProgressInterval = 100 * time.Millisecond
+)
+
+// newProgressThrottle returns a throttle for the given interval, using the
+// default interval if it is not positive.
+func newProgressThrottle(interval time.Duration) *progressThrottle {
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	return &progressThrottle{interval: interval}
+}
+
+// ready returns true if progress should be reported now, in which case the
+// interval is restarted.
+func (pt *progressThrottle) ready(force bool) bool {
+	now := time.Now()
+	if !force && now.Sub(pt.last) < pt.interval {
+		return false
+	}
+	pt.last = now
+	return true
+}
+
+// newDownloadProgressReader wraps the body of a download to report its
+// progress, starting at the given progress. The progress is reported once
+// right away so that the content length is known before the body is read.
+func newDownloadProgressReader(body io.ReadCloser, progress DownloadProgress, fn DownloadProgressFunc, interval time.Duration) *downloadProgressReader {
+	pr := &downloadProgressReader{
+		r:        body,
+		fn:       fn,
+		progress: progress,
+		throttle: newProgressThrottle(interval),
+	}
+	pr.throttle.ready(true)
+	fn(pr.progress)
+	return pr
+}
+
+// Close implements io.Closer.
+func (pr *downloadProgressReader) Close() error {
+	return pr.r.Close()
+}
+
+// Read implements io.Reader.
+func (pr *downloadProgressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.progress.BytesReceived += int64(n)
+	// Always report when the download is complete.
+	if err == io.EOF && !pr.done {
+		pr.done = true
+		pr.throttle.ready(true)
+		pr.fn(pr.progress)
+	} else if n > 0 && pr.throttle.ready(false) {
+		pr.fn(pr.progress)
+	}
+	return n, err
+}
+
+// Write implements io.Writer.
+func (pw *uploadProgressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.progress.FileBytes += int64(n)
+	pw.progress.TotalBytes += int64(n)
+	if n > 0 && pw.throttle.ready(false) {
+		pw.fn(*pw.progress)
+	}
+	return n, err
+}
+
+// readerSize returns the number of bytes left in the reader if it can be
+// determined without reading, or -1 otherwise. Files are assumed to be read
+// from the start.
+func readerSize(r io.Reader) int64 {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len())
+	case interface{ Stat() (os.FileInfo, error) }:
+		info, err := v.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		return info.Size()
+	default:
+		return -1
+	}
+}
+
+// uploadDataSize returns the combined size of the upload data, or -1 if the
+// size of any of the readers is unknown.
+func uploadDataSize(uploadData UploadData) int64 {
+	var total int64
+	for _, data := range uploadData {
+		size := readerSize(data)
+		if size < 0 {
+			return -1
+		}
+		total += size
+	}
+	return total
+}
@@ -0,0 +1,59 @@
+package skynet
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestProgressThrottle tests that progress reports are rate limited unless
+// forced.
+func TestProgressThrottle(t *testing.T) {
+	throttle := newProgressThrottle(time.Hour)
+	if !throttle.ready(false) {
+		t.Fatal("expected first report to be ready")
+	}
+	if throttle.ready(false) {
+		t.Fatal("expected report within interval to be throttled")
+	}
+	if !throttle.ready(true) {
+		t.Fatal("expected forced report to be ready")
+	}
+
+	// The default interval should be used if none is given.
+	throttle = newProgressThrottle(0)
+	if throttle.interval != DefaultProgressInterval {
+		t.Fatalf("expected interval %v, got %v", DefaultProgressInterval, throttle.interval)
+	}
+}
+
+// TestUploadDataSize tests determining the size of upload data up front.
+func TestUploadDataSize(t *testing.T) {
+	file, err := os.Open("testdata/indexhtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	uploadData := UploadData{
+		"file":   file,
+		"bytes":  bytes.NewReader([]byte("abc")),
+		"string": strings.NewReader("defgh"),
+	}
+	if size := uploadDataSize(uploadData); size != 18+3+5 {
+		t.Fatalf("expected size %v, got %v", 18+3+5, size)
+	}
+
+	// The total size is unknown if the size of any reader is unknown.
+	uploadData["pipe"] = ioutil.NopCloser(strings.NewReader("ijk"))
+	if size := uploadDataSize(uploadData); size != -1 {
+		t.Fatalf("expected unknown size, got %v", size)
+	}
+}
@@ -0,0 +1,418 @@
+package skynet
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+type (
+	// RegistryEntryType is the type of a registry entry, which determines how
+	// its data is interpreted and signed.
+	RegistryEntryType uint8
+
+	// RegistryEntry is an entry in the registry. Entries are stored under the
+	// public key of their owner and the hash of their data key, and replace
+	// each other based on their revision.
+	RegistryEntry struct {
+		// DataKey is the key of the entry, unique for each public key. It is
+		// hashed with HashDataKey before being sent to portals.
+		DataKey string
+		// Data is the data of the entry, at most RegistryDataSize bytes.
+		Data []byte
+		// Revision is the revision number of the entry.
+		Revision uint64
+	}
+
+	// SignedRegistryEntry is a registry entry along with its signature.
+	SignedRegistryEntry struct {
+		// Entry is the registry entry.
+		Entry RegistryEntry
+		// Signature is the ed25519 signature of the entry by the owner.
+		Signature []byte
+		// Type is the type of the entry.
+		Type RegistryEntryType
+	}
+
+	// GetEntryOptions contains the options used for getting registry entries.
+	GetEntryOptions struct {
+		Options
+	}
+
+	// SetEntryOptions contains the options used for setting registry
+	// entries.
+	SetEntryOptions struct {
+		Options
+	}
+
+	// GetEntryResponse contains the response for getting a registry entry.
+	GetEntryResponse struct {
+		// Data is the hex encoded data of the entry.
+		Data string `json:"data"`
+		// DataKey is the hex encoded hash of the data key.
+		DataKey string `json:"datakey"`
+		// Revision is the revision number of the entry.
+		Revision uint64 `json:"revision"`
+		// Signature is the hex encoded signature of the entry.
+		Signature string `json:"signature"`
+		// Type is the type of the entry.
+		Type RegistryEntryType `json:"type"`
+	}
+
+	// setEntryRequest is the request body for setting a registry entry.
+	setEntryRequest struct {
+		PublicKey registryPublicKey `json:"publickey"`
+		DataKey   string            `json:"datakey"`
+		Revision  uint64            `json:"revision"`
+		Signature [64]byte          `json:"signature"`
+		Data      []byte            `json:"data"`
+		Type      RegistryEntryType `json:"type"`
+	}
+
+	// registryPublicKey is the JSON encoding of public keys in registry
+	// requests.
+	registryPublicKey struct {
+		Algorithm string `json:"algorithm"`
+		Key       []byte `json:"key"`
+	}
+)
+
+const (
+	// RegistryDataSize is the maximum size of the data of a registry entry.
+	RegistryDataSize = 113
+
+	// RegistryTypeWithoutPubkey is the type of entries with arbitrary data.
+	RegistryTypeWithoutPubkey RegistryEntryType = 1
+	// RegistryTypeWithPubkey is the type of entries whose data starts with
+	// the hash of a host's public key.
+	RegistryTypeWithPubkey RegistryEntryType = 2
+
+	// registryPubKeyHashSize is the size of the hash of the host's public key
+	// at the start of the data of entries with a public key.
+	registryPubKeyHashSize = 20
+)
+
+var (
+	// DefaultGetEntryOptions contains the default options for getting
+	// registry entries.
+	DefaultGetEntryOptions = GetEntryOptions{
+		Options: DefaultOptions("/skynet/registry"),
+	}
+	// DefaultSetEntryOptions contains the default options for setting
+	// registry entries.
+	DefaultSetEntryOptions = SetEntryOptions{
+		Options: DefaultOptions("/skynet/registry"),
+	}
+
+	// ErrRegistryEntryNotFound is returned when a registry entry could not be
+	// found.
+	ErrRegistryEntryNotFound = errors.New("registry entry not found")
+
+	// ErrRegistryDataTooLarge is returned when the data of a registry entry
+	// exceeds RegistryDataSize.
+	ErrRegistryDataTooLarge = fmt.Errorf("registry entry data exceeds the maximum size of %v bytes", RegistryDataSize)
+
+	// ErrInvalidRegistryEntry is returned when a registry entry returned by a
+	// portal is malformed or its signature doesn't verify.
+	ErrInvalidRegistryEntry = errors.New("invalid registry entry")
+
+	// ErrRevisionConflict is returned when setting a registry entry failed
+	// because the registry already contains an entry with the same or a
+	// higher revision. It is returned along with ErrLowerRevision or
+	// ErrSameRevision.
+	ErrRevisionConflict = errors.New("registry entry revision conflicts with an existing entry")
+	// ErrLowerRevision is returned when setting a registry entry failed
+	// because the registry contains an entry with a higher revision.
+	ErrLowerRevision = errors.New("registry contains an entry with a higher revision")
+	// ErrSameRevision is returned when setting a registry entry failed
+	// because the registry contains an entry with the same revision.
+	ErrSameRevision = errors.New("registry contains an entry with the same revision")
+
+	// lowerRevisionMessages and sameRevisionMessages are the errors returned
+	// by skyd for revision conflicts.
+	lowerRevisionMessages = []string{"provided revision number is invalid"}
+	sameRevisionMessages  = []string{
+		"provided revision number is already registered",
+		"entry doesn't have enough pow to replace existing entry",
+	}
+)
+
+// GetEntry returns the registry entry of the given public key and data key.
+// The signature of the entry is verified before it is returned.
+// ErrRegistryEntryNotFound is returned if the entry doesn't exist.
+func (sc *SkynetClient) GetEntry(publicKey ed25519.PublicKey, dataKey string, opts GetEntryOptions) (SignedRegistryEntry, error) {
+	return sc.GetEntryWithContext(context.Background(), publicKey, dataKey, opts)
+}
+
+// GetEntryWithContext returns the registry entry of the given public key and
+// data key. The signature of the entry is verified before it is returned.
+// ErrRegistryEntryNotFound is returned if the entry doesn't exist.
+func (sc *SkynetClient) GetEntryWithContext(ctx context.Context, publicKey ed25519.PublicKey, dataKey string, opts GetEntryOptions) (SignedRegistryEntry, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return SignedRegistryEntry{}, fmt.Errorf("public key has incorrect size %v", len(publicKey))
+	}
+	hashedDataKey := HashDataKey(dataKey)
+	values := url.Values{}
+	values.Set("publickey", FormatPublicKey(publicKey))
+	values.Set("datakey", hex.EncodeToString(hashedDataKey[:]))
+
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options: opts.Options,
+			ctx:     ctx,
+			method:  "GET",
+			reqBody: &bytes.Buffer{},
+			query:   values,
+		},
+	)
+	if IsNotFound(err) {
+		return SignedRegistryEntry{}, ErrRegistryEntryNotFound
+	}
+	if err != nil {
+		return SignedRegistryEntry{}, errors.AddContext(err, "could not execute request")
+	}
+
+	respBody, err := parseResponseBody(resp)
+	if err != nil {
+		return SignedRegistryEntry{}, errors.AddContext(err, "could not parse response body")
+	}
+
+	var apiResponse GetEntryResponse
+	err = json.Unmarshal(respBody.Bytes(), &apiResponse)
+	if err != nil {
+		return SignedRegistryEntry{}, errors.AddContext(err, "could not unmarshal response JSON")
+	}
+
+	entry, err := apiResponse.decode(dataKey)
+	if err != nil {
+		return SignedRegistryEntry{}, errors.Compose(ErrInvalidRegistryEntry, err)
+	}
+	if err := entry.Verify(publicKey); err != nil {
+		return SignedRegistryEntry{}, errors.Compose(ErrInvalidRegistryEntry, err)
+	}
+	sc.revisionCache().update(publicKey, dataKey, entry.Entry.Revision)
+	return entry, nil
+}
+
+// SetEntry signs the given registry entry with the private key and sets it in
+// the registry. The revision has to be higher than the revision of any existing
+// entry, otherwise ErrRevisionConflict is returned. If the request was retried
+// and the registry contains the same entry, it was set by an earlier attempt
+// and no error is returned.
+func (sc *SkynetClient) SetEntry(privateKey ed25519.PrivateKey, entry RegistryEntry, opts SetEntryOptions) error {
+	return sc.SetEntryWithContext(context.Background(), privateKey, entry, opts)
+}
+
+// SetEntryWithContext signs the given registry entry with the private key and
+// sets it in the registry. The revision has to be higher than the revision of
+// any existing entry, otherwise ErrRevisionConflict is returned. If the request
+// was retried and the registry contains the same entry, it was set by an
+// earlier attempt and no error is returned.
+func (sc *SkynetClient) SetEntryWithContext(ctx context.Context, privateKey ed25519.PrivateKey, entry RegistryEntry, opts SetEntryOptions) error {
+	signedEntry, err := entry.Sign(privateKey)
+	if err != nil {
+		return errors.AddContext(err, "could not sign registry entry")
+	}
+	hashedDataKey := HashDataKey(entry.DataKey)
+	reqBody := setEntryRequest{
+		PublicKey: registryPublicKey{
+			Algorithm: "ed25519",
+			Key:       privateKey.Public().(ed25519.PublicKey),
+		},
+		DataKey:  hex.EncodeToString(hashedDataKey[:]),
+		Revision: entry.Revision,
+		Data:     entry.Data,
+		Type:     signedEntry.Type,
+	}
+	copy(reqBody.Signature[:], signedEntry.Signature)
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return errors.AddContext(err, "could not marshal request body")
+	}
+
+	var attempts int
+	resp, err := sc.executeRequest(
+		requestOptions{
+			Options:  opts.Options,
+			ctx:      ctx,
+			method:   "POST",
+			reqBody:  bytes.NewReader(body),
+			attempts: &attempts,
+		},
+	)
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	if err != nil {
+		conflictErr := revisionConflictError(err)
+		if conflictErr != nil {
+			// The registry contains an entry with at least this revision.
+			sc.revisionCache().update(publicKey, entry.DataKey, entry.Revision)
+		}
+		// An earlier attempt may have set the entry before failing with a
+		// transient error.
+		if attempts > 1 && errors.Contains(conflictErr, ErrSameRevision) && sc.hasEntry(ctx, publicKey, signedEntry, opts) {
+			return nil
+		}
+		return errors.AddContext(errors.Compose(conflictErr, err), "could not execute request")
+	}
+	sc.revisionCache().update(publicKey, entry.DataKey, entry.Revision)
+	return resp.Body.Close()
+}
+
+// HashDataKey returns the hash of the data key that a registry entry is stored
+// under, the same way as the other Skynet SDKs.
+func HashDataKey(dataKey string) [32]byte {
+	// The data key is encoded as a length-prefixed string.
+	b := make([]byte, 8+len(dataKey))
+	binary.LittleEndian.PutUint64(b, uint64(len(dataKey)))
+	copy(b[8:], dataKey)
+	return blake2b.Sum256(b)
+}
+
+// Verify verifies the data and signature of the entry with the public key of
+// its owner.
+func (sre SignedRegistryEntry) Verify(publicKey ed25519.PublicKey) error {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("public key has incorrect size %v", len(publicKey))
+	}
+	if len(sre.Entry.Data) > RegistryDataSize {
+		return ErrRegistryDataTooLarge
+	}
+	switch sre.Type {
+	case RegistryTypeWithoutPubkey:
+	case RegistryTypeWithPubkey:
+		if len(sre.Entry.Data) < registryPubKeyHashSize {
+			return errors.New("registry entry data is missing the host's public key hash")
+		}
+	default:
+		return fmt.Errorf("unknown registry entry type %v", sre.Type)
+	}
+	hash := sre.Entry.hash(sre.Type)
+	if !ed25519.Verify(publicKey, hash[:], sre.Signature) {
+		return errors.New("registry entry signature is invalid")
+	}
+	return nil
+}
+
+// Sign signs the entry with the given private key, returning an entry of type
+// RegistryTypeWithoutPubkey.
+func (re RegistryEntry) Sign(privateKey ed25519.PrivateKey) (SignedRegistryEntry, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return SignedRegistryEntry{}, fmt.Errorf("private key has incorrect size %v", len(privateKey))
+	}
+	if len(re.Data) > RegistryDataSize {
+		return SignedRegistryEntry{}, ErrRegistryDataTooLarge
+	}
+	hash := re.hash(RegistryTypeWithoutPubkey)
+	return SignedRegistryEntry{
+		Entry:     re,
+		Signature: ed25519.Sign(privateKey, hash[:]),
+		Type:      RegistryTypeWithoutPubkey,
+	}, nil
+}
+
+// hash returns the hash of the entry that is signed for entries of the given
+// type. It matches skyd's hash of the Sia encoding of the hashed data key, the
+// data, the revision and, for all but legacy entries, the type.
+func (re RegistryEntry) hash(entryType RegistryEntryType) [32]byte {
+	hashedDataKey := HashDataKey(re.DataKey)
+	b := make([]byte, 0, 32+8+len(re.Data)+8+8)
+	b = append(b, hashedDataKey[:]...)
+	b = appendUint64(b, uint64(len(re.Data)))
+	b = append(b, re.Data...)
+	b = appendUint64(b, re.Revision)
+	if entryType != RegistryTypeWithoutPubkey {
+		b = appendUint64(b, uint64(entryType))
+	}
+	return blake2b.Sum256(b)
+}
+
+// decode decodes the entry in the response, which has the given data key.
+func (ger GetEntryResponse) decode(dataKey string) (SignedRegistryEntry, error) {
+	hashedDataKey := HashDataKey(dataKey)
+	if ger.DataKey != "" && ger.DataKey != hex.EncodeToString(hashedDataKey[:]) {
+		return SignedRegistryEntry{}, errors.New("portal returned an entry with a different data key")
+	}
+	data, err := hex.DecodeString(ger.Data)
+	if err != nil {
+		return SignedRegistryEntry{}, errors.AddContext(err, "could not decode data")
+	}
+	signature, err := hex.DecodeString(ger.Signature)
+	if err != nil {
+		return SignedRegistryEntry{}, errors.AddContext(err, "could not decode signature")
+	}
+	if len(signature) != ed25519.SignatureSize {
+		return SignedRegistryEntry{}, fmt.Errorf("signature has incorrect size %v", len(signature))
+	}
+	return SignedRegistryEntry{
+		Entry: RegistryEntry{
+			DataKey:  dataKey,
+			Data:     data,
+			Revision: ger.Revision,
+		},
+		Signature: signature,
+		Type:      ger.Type,
+	}, nil
+}
+
+// lastResponseError returns the last ResponseError contained in the given
+// error, which is the one of the last attempt if the request was retried.
+func lastResponseError(err error) (*ResponseError, bool) {
+	composed, ok := err.(errors.Error)
+	if !ok {
+		return AsResponseError(err)
+	}
+	for i := len(composed.ErrSet) - 1; i >= 0; i-- {
+		if re, ok := lastResponseError(composed.ErrSet[i]); ok {
+			return re, true
+		}
+	}
+	return nil, false
+}
+
+// appendUint64 appends the little-endian encoding of the integer to the slice.
+func appendUint64(b []byte, u uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], u)
+	return append(b, buf[:]...)
+}
+
+// hasEntry returns whether the registry contains the signed entry.
+func (sc *SkynetClient) hasEntry(ctx context.Context, publicKey ed25519.PublicKey, signedEntry SignedRegistryEntry, opts SetEntryOptions) bool {
+	existing, err := sc.GetEntryWithContext(ctx, publicKey, signedEntry.Entry.DataKey, GetEntryOptions(opts))
+	if err != nil {
+		return false
+	}
+	return existing.Entry.Revision == signedEntry.Entry.Revision &&
+		bytes.Equal(existing.Entry.Data, signedEntry.Entry.Data) &&
+		existing.Type == signedEntry.Type
+}
+
+// revisionConflictError returns the revision conflict error for the error
+// returned when setting a registry entry, or nil if it isn't a revision
+// conflict. Only the response of the last attempt is considered.
+func revisionConflictError(err error) error {
+	re, ok := lastResponseError(err)
+	if !ok {
+		return nil
+	}
+	for _, msg := range lowerRevisionMessages {
+		if strings.Contains(re.Message, msg) {
+			return errors.Compose(ErrRevisionConflict, ErrLowerRevision)
+		}
+	}
+	for _, msg := range sameRevisionMessages {
+		if strings.Contains(re.Message, msg) {
+			return errors.Compose(ErrRevisionConflict, ErrSameRevision)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package skynet
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestHashDataKey tests hashing data keys against the hashes of the other
+// Skynet SDKs.
+func TestHashDataKey(t *testing.T) {
+	tests := []struct {
+		dataKey, hash string
+	}{
+		{"", "81e47a19e6b29b0a65b9591762ce5143ed30d0261e5d24a3201752506b20f15c"},
+		{"skynet", "31c7a4d53ef7bb4c7531181645a0037b9e75c8b1d1285b468ad58bad6262c777"},
+	}
+	for _, test := range tests {
+		hash := HashDataKey(test.dataKey)
+		if hex.EncodeToString(hash[:]) != test.hash {
+			t.Fatalf("%q: expected hash %v, got %x", test.dataKey, test.hash, hash)
+		}
+	}
+}
+
+// TestRegistryEntryHash tests that the hash of an entry matches the hash
+// computed by skyd for the same entry.
+func TestRegistryEntryHash(t *testing.T) {
+	const expected = "788dddf5232807611557a3dc0fa5f34012c2650526ba91d55411a2b04ba56164"
+	entry := RegistryEntry{DataKey: "HelloWorld", Data: []byte("abc"), Revision: 123456789}
+	hash := entry.hash(RegistryTypeWithoutPubkey)
+	if hex.EncodeToString(hash[:]) != expected {
+		t.Fatalf("expected hash %v, got %x", expected, hash)
+	}
+	// The type is signed for entries of other types.
+	if entry.hash(RegistryTypeWithPubkey) == hash {
+		t.Fatal("expected the type to change the hash")
+	}
+}
+
+// TestGetEntry tests getting and verifying registry entries.
+func TestGetEntry(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := RegistryEntry{DataKey: "app", Data: []byte("data"), Revision: 3}
+	hash := entry.hash(RegistryTypeWithoutPubkey)
+	hashedDataKey := HashDataKey(entry.DataKey)
+	response := GetEntryResponse{
+		Data:      hex.EncodeToString(entry.Data),
+		DataKey:   hex.EncodeToString(hashedDataKey[:]),
+		Revision:  entry.Revision,
+		Signature: hex.EncodeToString(ed25519.Sign(privateKey, hash[:])),
+		Type:      RegistryTypeWithoutPubkey,
+	}
+
+	// Serve the entry, modifying the response if requested.
+	var modify func(*GetEntryResponse)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if req.URL.Path != "/skynet/registry" ||
+			query.Get("publickey") != "ed25519:"+hex.EncodeToString(publicKey) ||
+			query.Get("datakey") != response.DataKey {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		resp := response
+		if modify != nil {
+			modify(&resp)
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+	client := NewCustom(server.URL, Options{HTTPClient: server.Client()})
+	opts := DefaultGetEntryOptions
+
+	signedEntry, err := client.GetEntry(publicKey, entry.DataKey, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(signedEntry.Entry.Data) != "data" || signedEntry.Entry.Revision != 3 || signedEntry.Entry.DataKey != "app" {
+		t.Fatalf("unexpected entry %+v", signedEntry.Entry)
+	}
+	if signedEntry.Type != RegistryTypeWithoutPubkey {
+		t.Fatalf("expected type %v, got %v", RegistryTypeWithoutPubkey, signedEntry.Type)
+	}
+
+	// Test an entry that doesn't exist.
+	_, err = client.GetEntry(publicKey, "other", opts)
+	if !errors.Contains(err, ErrRegistryEntryNotFound) {
+		t.Fatalf("expected error %v, got %v", ErrRegistryEntryNotFound, err)
+	}
+
+	// Test entries that don't verify.
+	tests := []func(*GetEntryResponse){
+		func(resp *GetEntryResponse) { resp.Data = hex.EncodeToString([]byte("date")) },
+		func(resp *GetEntryResponse) { resp.Revision++ },
+		func(resp *GetEntryResponse) { resp.Type = RegistryTypeWithPubkey },
+		func(resp *GetEntryResponse) { resp.Type = 0 },
+		func(resp *GetEntryResponse) { resp.Signature = resp.Signature[2:] },
+		func(resp *GetEntryResponse) { resp.DataKey = hex.EncodeToString(make([]byte, 32)) },
+	}
+	for i, test := range tests {
+		modify = test
+		_, err = client.GetEntry(publicKey, entry.DataKey, opts)
+		if !errors.Contains(err, ErrInvalidRegistryEntry) {
+			t.Fatalf("%v: expected error %v, got %v", i, ErrInvalidRegistryEntry, err)
+		}
+	}
+}
+
+// TestSetEntry tests signing and setting registry entries.
+func TestSetEntry(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := RegistryEntry{DataKey: "app", Data: []byte("data"), Revision: 3}
+
+	// Store the entry like a portal does, rejecting revisions that aren't
+	// higher than the stored one.
+	var stored *setEntryRequest
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body setEntryRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch {
+		case stored == nil || body.Revision > stored.Revision:
+			stored = &body
+			w.WriteHeader(http.StatusNoContent)
+		case body.Revision == stored.Revision:
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"message":"Unable to update the registry: provided revision number is already registered"}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"message":"Unable to update the registry: provided revision number is invalid"}`))
+		}
+	}))
+	defer server.Close()
+	client := NewCustom(server.URL, Options{HTTPClient: server.Client()})
+	opts := DefaultSetEntryOptions
+
+	err = client.SetEntry(privateKey, entry, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashedDataKey := HashDataKey(entry.DataKey)
+	if stored.PublicKey.Algorithm != "ed25519" || !bytes.Equal(stored.PublicKey.Key, publicKey) {
+		t.Fatalf("unexpected public key %+v", stored.PublicKey)
+	}
+	if stored.DataKey != hex.EncodeToString(hashedDataKey[:]) {
+		t.Fatalf("expected data key %x, got %v", hashedDataKey, stored.DataKey)
+	}
+	signedEntry := SignedRegistryEntry{
+		Entry:     RegistryEntry{DataKey: entry.DataKey, Data: stored.Data, Revision: stored.Revision},
+		Signature: stored.Signature[:],
+		Type:      stored.Type,
+	}
+	if err := signedEntry.Verify(publicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test revision conflicts.
+	err = client.SetEntry(privateKey, entry, opts)
+	if !errors.Contains(err, ErrRevisionConflict) || !errors.Contains(err, ErrSameRevision) {
+		t.Fatalf("expected error %v, got %v", ErrSameRevision, err)
+	}
+	entry.Revision--
+	err = client.SetEntry(privateKey, entry, opts)
+	if !errors.Contains(err, ErrRevisionConflict) || !errors.Contains(err, ErrLowerRevision) {
+		t.Fatalf("expected error %v, got %v", ErrLowerRevision, err)
+	}
+
+	// Test data that is too large.
+	entry.Revision = 4
+	entry.Data = make([]byte, RegistryDataSize+1)
+	err = client.SetEntry(privateKey, entry, opts)
+	if !errors.Contains(err, ErrRegistryDataTooLarge) {
+		t.Fatalf("expected error %v, got %v", ErrRegistryDataTooLarge, err)
+	}
+}
+
+// TestSetEntryRetry tests setting registry entries with requests that are
+// retried after they were processed by the portal.
+func TestSetEntryRetry(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Store the entry like a portal does, but respond with a transient error
+	// to the first request after processing it if failNext is set.
+	var stored *setEntryRequest
+	var posts int
+	failNext := true
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "GET" {
+			if stored == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(GetEntryResponse{
+				Data:      hex.EncodeToString(stored.Data),
+				DataKey:   stored.DataKey,
+				Revision:  stored.Revision,
+				Signature: hex.EncodeToString(stored.Signature[:]),
+				Type:      stored.Type,
+			})
+			return
+		}
+		posts++
+		var body setEntryRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conflict := stored != nil && body.Revision <= stored.Revision
+		if !conflict {
+			stored = &body
+		}
+		switch {
+		case failNext:
+			failNext = false
+			w.WriteHeader(http.StatusBadGateway)
+		case conflict:
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"message":"Unable to update the registry: provided revision number is already registered"}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	client := NewCustom(server.URL, Options{
+		HTTPClient: server.Client(),
+		RetryPolicy: RetryPolicy{
+			MaxAttempts:    2,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+		},
+	})
+	opts := DefaultSetEntryOptions
+
+	// The retry should find the entry that was set by the first attempt.
+	entry := RegistryEntry{DataKey: "app", Data: []byte("data"), Revision: 1}
+	err = client.SetEntry(privateKey, entry, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if posts != 2 {
+		t.Fatalf("expected 2 requests, got %v", posts)
+	}
+
+	// A different entry with the same revision should still conflict.
+	posts = 0
+	failNext = true
+	entry.Data = []byte("other data")
+	err = client.SetEntry(privateKey, entry, opts)
+	if !errors.Contains(err, ErrRevisionConflict) || !errors.Contains(err, ErrSameRevision) {
+		t.Fatalf("expected error %v, got %v", ErrSameRevision, err)
+	}
+	if posts != 2 {
+		t.Fatalf("expected 2 requests, got %v", posts)
+	}
+	if signedEntry, err := client.GetEntry(publicKey, entry.DataKey, DefaultGetEntryOptions); err != nil || string(signedEntry.Entry.Data) != "data" {
+		t.Fatalf("expected the first entry to be stored, got %+v (%v)", signedEntry.Entry, err)
+	}
+}
@@ -0,0 +1,181 @@
+package skynet
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// RetryPolicy configures how requests that failed with a transient error
+	// are retried. Only requests whose body can be replayed are retried, which
+	// includes all requests without a body.
+	RetryPolicy struct {
+		// MaxAttempts is the maximum number of attempts made for a request,
+		// including the first one. A value of 1 or less disables retries.
+		MaxAttempts int
+		// InitialBackoff is the time to wait before the first retry. The
+		// backoff doubles with every further retry.
+		InitialBackoff time.Duration
+		// MaxBackoff is the upper bound for the computed backoff and for
+		// waits requested by the portal through a Retry-After header. If it
+		// is 0, the backoff isn't limited and Retry-After waits are limited to
+		// maxRetryAfter.
+		MaxBackoff time.Duration
+	}
+)
+
+const (
+	// maxRetryAfter is the longest wait requested through a Retry-After
+	// header that is honoured if the retry policy has no MaxBackoff.
+	maxRetryAfter = time.Minute
+)
+
+var (
+	// DefaultRetryPolicy contains a sensible retry policy for use with public
+	// portals. Retries are disabled unless a policy is set in the options.
+	DefaultRetryPolicy = RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+	}
+
+	// retryableStatusCodes are the status codes of responses that indicate a
+	// transient failure.
+	retryableStatusCodes = map[int]struct{}{
+		http.StatusTooManyRequests:    {},
+		http.StatusBadGateway:         {},
+		http.StatusServiceUnavailable: {},
+		http.StatusGatewayTimeout:     {},
+	}
+)
+
+// backoff returns the time to wait before the given retry, starting at 1. It
+// grows exponentially and includes random jitter of up to half the backoff.
+func (rp RetryPolicy) backoff(retry int) time.Duration {
+	backoff := rp.InitialBackoff
+	for i := 1; i < retry && (rp.MaxBackoff <= 0 || backoff < rp.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if rp.MaxBackoff > 0 && backoff > rp.MaxBackoff {
+		backoff = rp.MaxBackoff
+	}
+	if backoff <= 1 {
+		return backoff
+	}
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// maxRetryAfter returns the longest wait requested through a Retry-After
+// header that is honoured.
+func (rp RetryPolicy) maxRetryAfter() time.Duration {
+	if rp.MaxBackoff > 0 {
+		return rp.MaxBackoff
+	}
+	return maxRetryAfter
+}
+
+// doWithRetries executes the request with the given client, retrying it
+// according to the retry policy, and returns the number of attempts made. If
+// more than one attempt was made, the returned error contains the errors of
+// every attempt.
+func doWithRetries(client *http.Client, req *http.Request, policy RetryPolicy) (*http.Response, int, error) {
+	var attemptErrs []error
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Do(req)
+		if err == nil && resp.StatusCode < 400 {
+			return resp, attempt, nil
+		}
+
+		// Collect the error of this attempt.
+		var attemptErr error
+		var wait time.Duration
+		retryable := true
+		if err != nil {
+			attemptErr = errors.AddContext(err, "could not execute request")
+			// Don't retry if the request was cancelled.
+			retryable = req.Context().Err() == nil
+		} else {
+			_, retryable = retryableStatusCodes[resp.StatusCode]
+			wait, _ = parseRetryAfter(resp.Header.Get("Retry-After"))
+			if wait > policy.maxRetryAfter() {
+				wait = policy.maxRetryAfter()
+			}
+			attemptErr = errors.AddContext(makeResponseError(resp), "error code received")
+		}
+		attemptErrs = append(attemptErrs, errors.AddContext(attemptErr, fmt.Sprintf("attempt %v failed", attempt)))
+
+		// Check whether we can make another attempt.
+		var nextReq *http.Request
+		if retryable && attempt < policy.MaxAttempts {
+			nextReq, err = rewindRequest(req)
+			retryable = err == nil
+		}
+		if !retryable || attempt >= policy.MaxAttempts {
+			if attempt == 1 {
+				return nil, attempt, attemptErr
+			}
+			return nil, attempt, errors.Compose(attemptErrs...)
+		}
+
+		// Wait before the next attempt.
+		if wait == 0 {
+			wait = policy.backoff(attempt)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			attemptErrs = append(attemptErrs, errors.AddContext(req.Context().Err(), "request cancelled while waiting to retry"))
+			return nil, attempt, errors.Compose(attemptErrs...)
+		case <-timer.C:
+		}
+		req = nextReq
+	}
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a
+// number of seconds or an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	wait := time.Until(date)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+// rewindRequest returns a copy of the request that can be sent again. It
+// returns an error if the request body cannot be replayed.
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	newReq := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return newReq, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("request body cannot be replayed")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, errors.AddContext(err, "could not replay request body")
+	}
+	newReq.Body = body
+	return newReq, nil
+}
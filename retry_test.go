@@ -0,0 +1,64 @@
+package skynet
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestParseRetryAfter tests parsing Retry-After header values.
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		wait  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{"3", 3 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, true},
+	}
+
+	for _, test := range tests {
+		wait, ok := parseRetryAfter(test.value)
+		if wait != test.wait || ok != test.ok {
+			t.Fatalf("%q: expected (%v, %v), got (%v, %v)", test.value, test.wait, test.ok, wait, ok)
+		}
+	}
+
+	// A date in the future should result in a positive wait.
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	wait, ok := parseRetryAfter(date)
+	if !ok || wait <= 0 || wait > time.Hour {
+		t.Fatalf("unexpected wait %v for date %v", wait, date)
+	}
+}
+
+// TestRetryPolicyBackoff tests that the backoff grows exponentially within the
+// jitter bounds and is capped at the max backoff.
+func TestRetryPolicyBackoff(t *testing.T) {
+	rp := RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Second,
+	}
+
+	expected := []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second,
+		10 * time.Second,
+	}
+	for i, limit := range expected {
+		for j := 0; j < 100; j++ {
+			backoff := rp.backoff(i + 1)
+			if backoff < limit/2 || backoff > limit {
+				t.Fatalf("retry %v: backoff %v not in [%v, %v]", i+1, backoff, limit/2, limit)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package skynet
+
+import (
+	"crypto/ed25519"
+	"sync"
+	"time"
+)
+
+type (
+	// revisionCache tracks the latest known revisions of registry entries and
+	// serialises writes to the same entry within the process. There is one
+	// cache per portal, which is shared by all clients using the portal.
+	revisionCache struct {
+		entries map[revisionCacheKey]*revisionCacheEntry
+		// lastPrune is the time the idle entries were last removed.
+		lastPrune time.Time
+		mu        sync.Mutex
+	}
+
+	// revisionCacheKey identifies a registry entry in the revision cache.
+	revisionCacheKey struct {
+		publicKey     string
+		hashedDataKey [32]byte
+	}
+
+	// revisionCacheEntry is the cached state of a registry entry.
+	revisionCacheEntry struct {
+		// revision is the latest known revision of the entry. It is only valid
+		// if known is set. All fields except writeMu are protected by the
+		// mutex of the cache.
+		revision uint64
+		known    bool
+
+		// lastUsed is the time the entry was last accessed, and writers is
+		// the number of writes that hold or wait for writeMu. Entries
+		// without writers that weren't used for revisionCacheTTL are removed.
+		lastUsed time.Time
+		writers  int
+
+		// writeMu is held while the entry is written.
+		writeMu sync.Mutex
+	}
+)
+
+const (
+	// revisionCacheTTL is the time after which unused entries are removed
+	// from the revision cache. Portals return the latest revision of an
+	// entry well within this time after it was updated.
+	revisionCacheTTL = 10 * time.Minute
+)
+
+var (
+	// revisionCaches contains the revision caches of the portals used by
+	// the clients of the process.
+	revisionCaches   = make(map[string]*revisionCache)
+	revisionCachesMu sync.Mutex
+)
+
+// newRevisionCache returns an empty revision cache.
+func newRevisionCache() *revisionCache {
+	return &revisionCache{
+		entries: make(map[revisionCacheKey]*revisionCacheEntry),
+	}
+}
+
+// revisionCacheOf returns the revision cache of the portal, creating it if
+// necessary.
+func revisionCacheOf(portalURL string) *revisionCache {
+	revisionCachesMu.Lock()
+	defer revisionCachesMu.Unlock()
+	rc, ok := revisionCaches[portalURL]
+	if !ok {
+		rc = newRevisionCache()
+		revisionCaches[portalURL] = rc
+	}
+	return rc
+}
+
+// entry returns the cache entry of the registry entry, creating it if
+// necessary. The mutex of the cache must be held.
+func (rc *revisionCache) entry(publicKey ed25519.PublicKey, dataKey string) *revisionCacheEntry {
+	rc.prune()
+	key := revisionCacheKey{
+		publicKey:     string(publicKey),
+		hashedDataKey: HashDataKey(dataKey),
+	}
+	entry, ok := rc.entries[key]
+	if !ok {
+		entry = &revisionCacheEntry{}
+		rc.entries[key] = entry
+	}
+	entry.lastUsed = time.Now()
+	return entry
+}
+
+// prune removes the entries that have no writers and weren't used for
+// revisionCacheTTL. The entries are checked at most once per revisionCacheTTL.
+// The mutex of the cache must be held.
+func (rc *revisionCache) prune() {
+	now := time.Now()
+	if now.Sub(rc.lastPrune) < revisionCacheTTL {
+		return
+	}
+	rc.lastPrune = now
+	for key, entry := range rc.entries {
+		if entry.writers == 0 && now.Sub(entry.lastUsed) >= revisionCacheTTL {
+			delete(rc.entries, key)
+		}
+	}
+}
+
+// lock blocks until no other write to the registry entry is in progress and
+// returns a function that releases the lock.
+func (rc *revisionCache) lock(publicKey ed25519.PublicKey, dataKey string) (unlock func()) {
+	rc.mu.Lock()
+	entry := rc.entry(publicKey, dataKey)
+	entry.writers++
+	rc.mu.Unlock()
+
+	entry.writeMu.Lock()
+	return func() {
+		entry.writeMu.Unlock()
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		entry.writers--
+		entry.lastUsed = time.Now()
+	}
+}
+
+// latest returns the latest known revision of the registry entry and whether
+// a revision is known.
+func (rc *revisionCache) latest(publicKey ed25519.PublicKey, dataKey string) (uint64, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	entry := rc.entry(publicKey, dataKey)
+	return entry.revision, entry.known
+}
+
+// update records that the registry entry exists with at least the given
+// revision.
+func (rc *revisionCache) update(publicKey ed25519.PublicKey, dataKey string, revision uint64) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	entry := rc.entry(publicKey, dataKey)
+	if !entry.known || revision > entry.revision {
+		entry.revision = revision
+		entry.known = true
+	}
+}
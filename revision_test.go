@@ -0,0 +1,67 @@
+package skynet
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRevisionCache tests tracking the latest known revisions of entries.
+func TestRevisionCache(t *testing.T) {
+	keyPair := GenKeyPairFromSeed("test seed")
+	otherKeyPair := GenKeyPairFromSeed("other seed")
+	rc := newRevisionCache()
+
+	if _, known := rc.latest(keyPair.PublicKey, "app"); known {
+		t.Fatal("expected no known revision")
+	}
+	rc.update(keyPair.PublicKey, "app", 0)
+	if revision, known := rc.latest(keyPair.PublicKey, "app"); !known || revision != 0 {
+		t.Fatalf("expected revision 0, got %v (known %v)", revision, known)
+	}
+
+	// The revision should never decrease.
+	rc.update(keyPair.PublicKey, "app", 5)
+	rc.update(keyPair.PublicKey, "app", 3)
+	if revision, _ := rc.latest(keyPair.PublicKey, "app"); revision != 5 {
+		t.Fatalf("expected revision 5, got %v", revision)
+	}
+
+	// Other entries should not be affected.
+	if _, known := rc.latest(keyPair.PublicKey, "other"); known {
+		t.Fatal("expected no known revision for another data key")
+	}
+	if _, known := rc.latest(otherKeyPair.PublicKey, "app"); known {
+		t.Fatal("expected no known revision for another public key")
+	}
+}
+
+// TestRevisionCachePrune tests removing unused entries from the revision
+// cache.
+func TestRevisionCachePrune(t *testing.T) {
+	keyPair := GenKeyPairFromSeed("test seed")
+	rc := newRevisionCache()
+	rc.update(keyPair.PublicKey, "idle", 1)
+	rc.update(keyPair.PublicKey, "locked", 2)
+	unlock := rc.lock(keyPair.PublicKey, "locked")
+
+	// Make both entries idle.
+	rc.mu.Lock()
+	for _, entry := range rc.entries {
+		entry.lastUsed = time.Now().Add(-revisionCacheTTL)
+	}
+	rc.lastPrune = time.Now().Add(-revisionCacheTTL)
+	rc.mu.Unlock()
+
+	// Only the entry without writers should be removed.
+	rc.update(keyPair.PublicKey, "other", 3)
+	if _, known := rc.latest(keyPair.PublicKey, "idle"); known {
+		t.Fatal("expected the idle entry to be removed")
+	}
+	if revision, known := rc.latest(keyPair.PublicKey, "locked"); !known || revision != 2 {
+		t.Fatalf("expected revision 2, got %v (known %v)", revision, known)
+	}
+	unlock()
+	if len(rc.entries) != 3 {
+		t.Fatalf("expected 3 entries, got %v", len(rc.entries))
+	}
+}
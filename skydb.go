@@ -0,0 +1,468 @@
+package skynet
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// GetJSONOptions contains the options used for getting SkyDB JSON.
+	GetJSONOptions struct {
+		// GetEntryOptions are the options used for getting the registry
+		// entry.
+		GetEntryOptions GetEntryOptions
+		// DownloadOptions are the options used for downloading the JSON.
+		DownloadOptions DownloadOptions
+	}
+
+	// SetJSONOptions contains the options used for setting SkyDB JSON.
+	SetJSONOptions struct {
+		// GetEntryOptions are the options used for getting the registry
+		// entry to determine the next revision.
+		GetEntryOptions GetEntryOptions
+		// SetEntryOptions are the options used for setting the registry
+		// entry.
+		SetEntryOptions SetEntryOptions
+		// UploadOptions are the options used for uploading the JSON.
+		UploadOptions UploadOptions
+		// DownloadOptions are the options used for downloading the latest
+		// JSON to merge it.
+		DownloadOptions DownloadOptions
+		// Revision is the revision to write. If nil, the revision following
+		// the latest known revision of the entry is used.
+		Revision *uint64
+
+		// Merge is called when the entry was updated concurrently, with the
+		// latest JSON, and returns the value to store instead. The write is
+		// then retried with the next revision. If nil, ErrRevisionConflict is
+		// returned on conflicts.
+		Merge JSONMergeFunc
+		// MaxConflictRetries is the maximum number of times a write is
+		// retried after a conflict.
+		MaxConflictRetries int
+	}
+
+	// GetRawBytesOptions contains the options used for getting raw bytes
+	// from SkyDB.
+	GetRawBytesOptions struct {
+		// GetEntryOptions are the options used for getting the registry
+		// entry.
+		GetEntryOptions GetEntryOptions
+	}
+
+	// SetRawBytesOptions contains the options used for setting raw bytes in
+	// SkyDB.
+	SetRawBytesOptions struct {
+		// GetEntryOptions are the options used for getting the registry
+		// entry to determine the next revision. The entry isn't fetched if
+		// the revision is set.
+		GetEntryOptions GetEntryOptions
+		// SetEntryOptions are the options used for setting the registry
+		// entry.
+		SetEntryOptions SetEntryOptions
+		// Revision is the revision to write. If nil, the revision following
+		// the latest known revision of the entry is used.
+		Revision *uint64
+
+		// Merge is called when the entry was updated concurrently, with the
+		// data of the latest entry, and returns the data to write instead.
+		// The write is then retried with the next revision. If nil,
+		// ErrRevisionConflict is returned on conflicts.
+		Merge MergeFunc
+		// MaxConflictRetries is the maximum number of times a write is
+		// retried after a conflict.
+		MaxConflictRetries int
+	}
+	// SetDataLinkOptions contains the options used for setting data links in
+	// SkyDB.
+	SetDataLinkOptions SetRawBytesOptions
+	// DeleteEntryOptions contains the options used for deleting SkyDB
+	// entries.
+	DeleteEntryOptions SetRawBytesOptions
+
+	// MergeFunc returns the data to write to a SkyDB entry given the data of
+	// its latest revision, which is nil if the entry doesn't exist or was
+	// deleted.
+	MergeFunc func(current []byte) ([]byte, error)
+
+	// JSONMergeFunc returns the value to store as SkyDB JSON given the JSON of
+	// the latest revision, which is nil if there is no JSON or it was
+	// deleted.
+	JSONMergeFunc func(current json.RawMessage) (interface{}, error)
+
+	// skydbJSON is the envelope the JSON is stored in, which is compatible
+	// with the other Skynet SDKs.
+	skydbJSON struct {
+		Data    json.RawMessage `json:"_data"`
+		Version int             `json:"_v"`
+	}
+)
+
+const (
+	// skydbJSONVersion is the version of the SkyDB JSON envelope.
+	skydbJSONVersion = 2
+)
+
+var (
+	// DefaultGetJSONOptions contains the default options for getting SkyDB
+	// JSON.
+	DefaultGetJSONOptions = GetJSONOptions{
+		GetEntryOptions: DefaultGetEntryOptions,
+		DownloadOptions: DefaultDownloadOptions,
+	}
+	// DefaultSetJSONOptions contains the default options for setting SkyDB
+	// JSON.
+	DefaultSetJSONOptions = SetJSONOptions{
+		GetEntryOptions:    DefaultGetEntryOptions,
+		SetEntryOptions:    DefaultSetEntryOptions,
+		UploadOptions:      DefaultUploadOptions,
+		DownloadOptions:    DefaultDownloadOptions,
+		Revision:           nil,
+		Merge:              nil,
+		MaxConflictRetries: 3,
+	}
+	// DefaultGetRawBytesOptions contains the default options for getting raw
+	// bytes from SkyDB.
+	DefaultGetRawBytesOptions = GetRawBytesOptions{
+		GetEntryOptions: DefaultGetEntryOptions,
+	}
+	// DefaultSetRawBytesOptions contains the default options for setting raw
+	// bytes in SkyDB.
+	DefaultSetRawBytesOptions = SetRawBytesOptions{
+		GetEntryOptions:    DefaultGetEntryOptions,
+		SetEntryOptions:    DefaultSetEntryOptions,
+		Revision:           nil,
+		Merge:              nil,
+		MaxConflictRetries: 3,
+	}
+	// DefaultSetDataLinkOptions contains the default options for setting data
+	// links in SkyDB.
+	DefaultSetDataLinkOptions = SetDataLinkOptions(DefaultSetRawBytesOptions)
+	// DefaultDeleteEntryOptions contains the default options for deleting
+	// SkyDB entries.
+	DefaultDeleteEntryOptions = DeleteEntryOptions(DefaultSetRawBytesOptions)
+
+	// tombstone is the data of deleted entries, an empty raw skylink like the
+	// other Skynet SDKs use.
+	tombstone = make([]byte, rawSkylinkSize)
+)
+
+// GetJSON gets the JSON stored in SkyDB under the given public key and data
+// key, decodes it into v and returns the skylink of the JSON.
+// ErrRegistryEntryNotFound is returned if there is no JSON or it was deleted.
+func (sc *SkynetClient) GetJSON(publicKey ed25519.PublicKey, dataKey string, v interface{}, opts GetJSONOptions) (Skylink, error) {
+	return sc.GetJSONWithContext(context.Background(), publicKey, dataKey, v, opts)
+}
+
+// GetJSONWithContext gets the JSON stored in SkyDB under the given public key
+// and data key, decodes it into v and returns the skylink of the JSON.
+// ErrRegistryEntryNotFound is returned if there is no JSON or it was deleted.
+func (sc *SkynetClient) GetJSONWithContext(ctx context.Context, publicKey ed25519.PublicKey, dataKey string, v interface{}, opts GetJSONOptions) (Skylink, error) {
+	entry, err := sc.GetEntryWithContext(ctx, publicKey, dataKey, opts.GetEntryOptions)
+	if err != nil {
+		return Skylink{}, errors.AddContext(err, "could not get registry entry")
+	}
+	if bytes.Equal(entry.Entry.Data, tombstone) {
+		return Skylink{}, ErrRegistryEntryNotFound
+	}
+	skylink, err := skylinkFromEntryData(entry.Entry.Data)
+	if err != nil {
+		return Skylink{}, errors.AddContext(err, "registry entry doesn't contain a skylink")
+	}
+
+	data, err := sc.downloadJSON(ctx, skylink, opts.DownloadOptions)
+	if err != nil {
+		return Skylink{}, err
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return Skylink{}, errors.AddContext(err, "could not unmarshal JSON")
+	}
+	return skylink, nil
+}
+
+// SetJSON stores v as JSON in SkyDB under the public key of the given private
+// key and the data key. The JSON is uploaded and the registry entry is updated
+// to point to it with the revision from the options or the next revision.
+// Conflicting writes by others are resolved with the merge function of the
+// options. The skylink of the stored JSON is returned.
+func (sc *SkynetClient) SetJSON(privateKey ed25519.PrivateKey, dataKey string, v interface{}, opts SetJSONOptions) (Skylink, error) {
+	return sc.SetJSONWithContext(context.Background(), privateKey, dataKey, v, opts)
+}
+
+// SetJSONWithContext stores v as JSON in SkyDB under the public key of the
+// given private key and the data key. The JSON is uploaded and the registry
+// entry is updated to point to it with the revision from the options or the
+// next revision. Conflicting writes by others are resolved with the merge
+// function of the options. The skylink of the stored JSON is returned.
+func (sc *SkynetClient) SetJSONWithContext(ctx context.Context, privateKey ed25519.PrivateKey, dataKey string, v interface{}, opts SetJSONOptions) (Skylink, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return Skylink{}, fmt.Errorf("private key has incorrect size %v", len(privateKey))
+	}
+	skylink, err := sc.uploadJSON(ctx, dataKey, v, opts.UploadOptions)
+	if err != nil {
+		return Skylink{}, err
+	}
+
+	setOpts := SetRawBytesOptions{
+		GetEntryOptions:    opts.GetEntryOptions,
+		SetEntryOptions:    opts.SetEntryOptions,
+		Revision:           opts.Revision,
+		MaxConflictRetries: opts.MaxConflictRetries,
+	}
+	if opts.Merge != nil {
+		// Merge the value with the latest JSON and point the entry to the
+		// upload of the merged value.
+		setOpts.Merge = func(current []byte) ([]byte, error) {
+			var currentJSON json.RawMessage
+			if current != nil {
+				currentSkylink, err := skylinkFromEntryData(current)
+				if err != nil {
+					return nil, errors.AddContext(err, "registry entry doesn't contain a skylink")
+				}
+				currentJSON, err = sc.downloadJSON(ctx, currentSkylink, opts.DownloadOptions)
+				if err != nil {
+					return nil, err
+				}
+			}
+			merged, err := opts.Merge(currentJSON)
+			if err != nil {
+				return nil, err
+			}
+			skylink, err = sc.uploadJSON(ctx, dataKey, merged, opts.UploadOptions)
+			if err != nil {
+				return nil, err
+			}
+			return skylink.Bytes(), nil
+		}
+	}
+	_, err = sc.setEntryData(ctx, privateKey, dataKey, skylink.Bytes(), setOpts)
+	if err != nil {
+		return Skylink{}, err
+	}
+	return skylink, nil
+}
+
+// GetRawBytes returns the data stored directly in the SkyDB entry of the given
+// public key and data key, along with the revision of the entry.
+// ErrRegistryEntryNotFound is returned if the entry doesn't exist or was
+// deleted.
+func (sc *SkynetClient) GetRawBytes(publicKey ed25519.PublicKey, dataKey string, opts GetRawBytesOptions) (data []byte, revision uint64, err error) {
+	return sc.GetRawBytesWithContext(context.Background(), publicKey, dataKey, opts)
+}
+
+// GetRawBytesWithContext returns the data stored directly in the SkyDB entry of
+// the given public key and data key, along with the revision of the entry.
+// ErrRegistryEntryNotFound is returned if the entry doesn't exist or was
+// deleted.
+func (sc *SkynetClient) GetRawBytesWithContext(ctx context.Context, publicKey ed25519.PublicKey, dataKey string, opts GetRawBytesOptions) (data []byte, revision uint64, err error) {
+	entry, err := sc.GetEntryWithContext(ctx, publicKey, dataKey, opts.GetEntryOptions)
+	if err != nil {
+		return nil, 0, errors.AddContext(err, "could not get registry entry")
+	}
+	if bytes.Equal(entry.Entry.Data, tombstone) {
+		return nil, entry.Entry.Revision, ErrRegistryEntryNotFound
+	}
+	return entry.Entry.Data, entry.Entry.Revision, nil
+}
+
+// SetRawBytes stores the data directly in the SkyDB entry of the public key of
+// the given private key and the data key, and returns the revision that was
+// written. The data can be at most RegistryDataSize bytes. Writes to the same
+// entry by the client are serialised, and conflicting writes by others are
+// resolved with the merge function of the options.
+func (sc *SkynetClient) SetRawBytes(privateKey ed25519.PrivateKey, dataKey string, data []byte, opts SetRawBytesOptions) (revision uint64, err error) {
+	return sc.SetRawBytesWithContext(context.Background(), privateKey, dataKey, data, opts)
+}
+
+// SetRawBytesWithContext stores the data directly in the SkyDB entry of the
+// public key of the given private key and the data key, and returns the
+// revision that was written. The data can be at most RegistryDataSize bytes.
+// Writes to the same entry by the client are serialised, and conflicting writes
+// by others are resolved with the merge function of the options.
+func (sc *SkynetClient) SetRawBytesWithContext(ctx context.Context, privateKey ed25519.PrivateKey, dataKey string, data []byte, opts SetRawBytesOptions) (revision uint64, err error) {
+	return sc.setEntryData(ctx, privateKey, dataKey, data, opts)
+}
+
+// SetDataLink points the SkyDB entry of the public key of the given private key
+// and the data key to the skylink, and returns the revision that was written.
+func (sc *SkynetClient) SetDataLink(privateKey ed25519.PrivateKey, dataKey string, dataLink string, opts SetDataLinkOptions) (revision uint64, err error) {
+	return sc.SetDataLinkWithContext(context.Background(), privateKey, dataKey, dataLink, opts)
+}
+
+// SetDataLinkWithContext points the SkyDB entry of the public key of the given
+// private key and the data key to the skylink, and returns the revision that
+// was written.
+func (sc *SkynetClient) SetDataLinkWithContext(ctx context.Context, privateKey ed25519.PrivateKey, dataKey string, dataLink string, opts SetDataLinkOptions) (revision uint64, err error) {
+	skylink, err := ParseSkylink(dataLink)
+	if err != nil {
+		return 0, errors.AddContext(err, "could not parse data link")
+	}
+	return sc.setEntryData(ctx, privateKey, dataKey, skylink.Bytes(), SetRawBytesOptions(opts))
+}
+
+// DeleteEntry deletes the SkyDB entry of the public key of the given private
+// key and the data key, and returns the revision that was written. Entries
+// can't be removed from the registry, so the entry is overwritten with a
+// tombstone that is recognized by the SkyDB methods of all Skynet SDKs.
+func (sc *SkynetClient) DeleteEntry(privateKey ed25519.PrivateKey, dataKey string, opts DeleteEntryOptions) (revision uint64, err error) {
+	return sc.DeleteEntryWithContext(context.Background(), privateKey, dataKey, opts)
+}
+
+// DeleteEntryWithContext deletes the SkyDB entry of the public key of the given
+// private key and the data key, and returns the revision that was written.
+// Entries can't be removed from the registry, so the entry is overwritten with
+// a tombstone that is recognized by the SkyDB methods of all Skynet SDKs.
+func (sc *SkynetClient) DeleteEntryWithContext(ctx context.Context, privateKey ed25519.PrivateKey, dataKey string, opts DeleteEntryOptions) (revision uint64, err error) {
+	return sc.setEntryData(ctx, privateKey, dataKey, tombstone, SetRawBytesOptions(opts))
+}
+
+// setEntryData sets the data of the registry entry with the revision from the
+// options, or the next revision, and returns the revision that was written.
+// Conflicts are retried with the merged data if the options contain a merge
+// function.
+func (sc *SkynetClient) setEntryData(ctx context.Context, privateKey ed25519.PrivateKey, dataKey string, data []byte, opts SetRawBytesOptions) (uint64, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return 0, fmt.Errorf("private key has incorrect size %v", len(privateKey))
+	}
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	unlock := sc.revisionCache().lock(publicKey, dataKey)
+	defer unlock()
+
+	var revision uint64
+	if opts.Revision != nil {
+		revision = *opts.Revision
+	} else {
+		var err error
+		revision, _, err = sc.nextRevision(ctx, publicKey, dataKey, opts.GetEntryOptions)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for retry := 0; ; retry++ {
+		if len(data) > RegistryDataSize {
+			return 0, ErrRegistryDataTooLarge
+		}
+		entry := RegistryEntry{
+			DataKey:  dataKey,
+			Data:     data,
+			Revision: revision,
+		}
+		err := sc.SetEntryWithContext(ctx, privateKey, entry, opts.SetEntryOptions)
+		if err == nil {
+			return revision, nil
+		}
+		if !errors.Contains(err, ErrRevisionConflict) || opts.Merge == nil || retry >= opts.MaxConflictRetries {
+			return 0, errors.AddContext(err, "could not set registry entry")
+		}
+
+		// Merge the data with the latest entry and try again.
+		var current []byte
+		revision, current, err = sc.nextRevision(ctx, publicKey, dataKey, opts.GetEntryOptions)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(current, tombstone) {
+			current = nil
+		}
+		data, err = opts.Merge(current)
+		if err != nil {
+			return 0, errors.AddContext(err, "could not merge registry entry data")
+		}
+	}
+}
+
+// nextRevision returns the revision following the latest known revision of the
+// registry entry, or 0 if the entry doesn't exist, along with the data of the
+// entry. The revision is never lower than the one following the revisions
+// previously seen by the client, as portals may return outdated entries right
+// after an update.
+func (sc *SkynetClient) nextRevision(ctx context.Context, publicKey ed25519.PublicKey, dataKey string, opts GetEntryOptions) (uint64, []byte, error) {
+	var data []byte
+	entry, err := sc.GetEntryWithContext(ctx, publicKey, dataKey, opts)
+	if err != nil && !errors.Contains(err, ErrRegistryEntryNotFound) {
+		return 0, nil, errors.AddContext(err, "could not get registry entry")
+	}
+	if err == nil {
+		data = entry.Entry.Data
+	}
+
+	// Fetched entries update the cache, so the cached revision is the latest
+	// known one.
+	latest, known := sc.revisionCache().latest(publicKey, dataKey)
+	if !known {
+		return 0, data, nil
+	}
+	if latest == math.MaxUint64 {
+		return 0, nil, errors.New("registry entry has the maximum revision")
+	}
+	return latest + 1, data, nil
+}
+
+// uploadJSON uploads v as JSON wrapped in the SkyDB envelope and returns its
+// skylink.
+func (sc *SkynetClient) uploadJSON(ctx context.Context, dataKey string, v interface{}, opts UploadOptions) (Skylink, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return Skylink{}, errors.AddContext(err, "could not marshal JSON")
+	}
+	data, err = json.Marshal(skydbJSON{Data: data, Version: skydbJSONVersion})
+	if err != nil {
+		return Skylink{}, errors.AddContext(err, "could not marshal JSON")
+	}
+
+	// The file is named after the hashed data key, like in the other SDKs.
+	hashedDataKey := HashDataKey(dataKey)
+	// The filename has no extension, so set the content type explicitly.
+	uploadData := UploadData{"dk:" + hex.EncodeToString(hashedDataKey[:]): bytes.NewReader(data)}
+	opts.fileContentType = "application/json"
+	skylink, err := sc.UploadWithContext(ctx, uploadData, opts)
+	if err != nil {
+		return Skylink{}, errors.AddContext(err, "could not upload JSON")
+	}
+	return skylink, nil
+}
+
+// downloadJSON downloads the JSON of the skylink and returns it without the
+// SkyDB envelope.
+func (sc *SkynetClient) downloadJSON(ctx context.Context, skylink Skylink, opts DownloadOptions) (json.RawMessage, error) {
+	body, err := sc.DownloadWithContext(ctx, skylink.String(), opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not download JSON")
+	}
+	data, err := ioutil.ReadAll(body)
+	err = errors.Compose(err, body.Close())
+	if err != nil {
+		return nil, errors.AddContext(err, "could not read JSON")
+	}
+
+	// JSON set by older SDKs isn't wrapped in the envelope.
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err == nil {
+		_, hasData := fields["_data"]
+		_, hasVersion := fields["_v"]
+		if hasData && hasVersion {
+			data = fields["_data"]
+		}
+	}
+	return data, nil
+}
+
+// skylinkFromEntryData returns the skylink stored in the data of a registry
+// entry. The skylink is either stored in its raw form or, by older SDKs,
+// base64 encoded.
+func skylinkFromEntryData(data []byte) (Skylink, error) {
+	if len(data) == base64EncodedSkylinkSize {
+		return ParseSkylink(string(data))
+	}
+	return SkylinkFromBytes(data)
+}
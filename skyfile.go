@@ -0,0 +1,136 @@
+package skynet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// skyfileLayout is the layout at the start of the base sector of a
+	// skyfile, describing the data that follows it.
+	skyfileLayout struct {
+		version            uint8
+		filesize           uint64
+		metadataSize       uint64
+		fanoutSize         uint64
+		fanoutDataPieces   uint8
+		fanoutParityPieces uint8
+		cipherType         [8]byte
+		keyData            [64]byte
+	}
+)
+
+const (
+	// sectorSize is the size of a sector on Sia.
+	sectorSize = 1 << 22
+
+	// skyfileLayoutSize is the size of an encoded skyfile layout.
+	skyfileLayoutSize = 99
+
+	// skyfileVersion is the only supported version of the skyfile layout.
+	skyfileVersion = 1
+)
+
+var (
+	// cipherTypePlain is the cipher type of unencrypted skyfiles.
+	cipherTypePlain = [8]byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+	// defaultTryFiles are the try files portals set for uploads that don't
+	// specify try files or a default path.
+	defaultTryFiles = []string{"index.html"}
+)
+
+// decode decodes the layout from the start of the data, which must be at
+// least skyfileLayoutSize bytes long.
+func (sl *skyfileLayout) decode(b []byte) {
+	sl.version = b[0]
+	sl.filesize = binary.LittleEndian.Uint64(b[1:])
+	sl.metadataSize = binary.LittleEndian.Uint64(b[9:])
+	sl.fanoutSize = binary.LittleEndian.Uint64(b[17:])
+	sl.fanoutDataPieces = b[25]
+	sl.fanoutParityPieces = b[26]
+	copy(sl.cipherType[:], b[27:35])
+	copy(sl.keyData[:], b[35:99])
+}
+
+// encode returns the encoded layout.
+func (sl skyfileLayout) encode() []byte {
+	b := make([]byte, skyfileLayoutSize)
+	b[0] = sl.version
+	binary.LittleEndian.PutUint64(b[1:], sl.filesize)
+	binary.LittleEndian.PutUint64(b[9:], sl.metadataSize)
+	binary.LittleEndian.PutUint64(b[17:], sl.fanoutSize)
+	b[25] = sl.fanoutDataPieces
+	b[26] = sl.fanoutParityPieces
+	copy(b[27:35], sl.cipherType[:])
+	copy(b[35:99], sl.keyData[:])
+	return b
+}
+
+// parseBaseSector parses the layout and metadata of an unencrypted skyfile
+// from its base sector, and returns the content if it is stored in the base
+// sector.
+func parseBaseSector(baseSector []byte) (skyfileLayout, SkyfileMetadata, []byte, error) {
+	if len(baseSector) < skyfileLayoutSize {
+		return skyfileLayout{}, SkyfileMetadata{}, nil, errors.New("base sector is too small for the skyfile layout")
+	}
+	var layout skyfileLayout
+	layout.decode(baseSector)
+	if layout.version != skyfileVersion {
+		return skyfileLayout{}, SkyfileMetadata{}, nil, fmt.Errorf("unsupported skyfile version %v", layout.version)
+	}
+	if layout.cipherType != cipherTypePlain {
+		return skyfileLayout{}, SkyfileMetadata{}, nil, errors.New("skyfile is encrypted")
+	}
+
+	// Check the bounds before converting the sizes to avoid overflows.
+	offset := uint64(skyfileLayoutSize)
+	remaining := uint64(len(baseSector)) - offset
+	if layout.fanoutSize > remaining || layout.metadataSize > remaining-layout.fanoutSize {
+		return skyfileLayout{}, SkyfileMetadata{}, nil, errors.New("fanout and metadata exceed the base sector")
+	}
+	offset += layout.fanoutSize
+
+	var metadata SkyfileMetadata
+	err := json.Unmarshal(baseSector[offset:offset+layout.metadataSize], &metadata)
+	if err != nil {
+		return skyfileLayout{}, SkyfileMetadata{}, nil, errors.AddContext(err, "could not parse skyfile metadata")
+	}
+	offset += layout.metadataSize
+
+	// The content is only stored in the base sector if there is no fanout.
+	if layout.fanoutSize != 0 {
+		return layout, metadata, nil, nil
+	}
+	if layout.filesize > uint64(len(baseSector))-offset {
+		return skyfileLayout{}, SkyfileMetadata{}, nil, errors.New("base sector doesn't contain the full content")
+	}
+	return layout, metadata, baseSector[offset : offset+layout.filesize], nil
+}
+
+// buildBaseSector returns the base sector of an unencrypted skyfile whose
+// content fits in the base sector along with the metadata, the way a portal
+// builds it. The base sector isn't padded to the size of a sector.
+func buildBaseSector(metadata SkyfileMetadata, content []byte) ([]byte, error) {
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not marshal skyfile metadata")
+	}
+	if skyfileLayoutSize+len(metadataBytes)+len(content) > sectorSize {
+		return nil, ErrContentTooLarge
+	}
+	layout := skyfileLayout{
+		version:      skyfileVersion,
+		filesize:     uint64(len(content)),
+		metadataSize: uint64(len(metadataBytes)),
+		cipherType:   cipherTypePlain,
+	}
+	baseSector := make([]byte, 0, skyfileLayoutSize+len(metadataBytes)+len(content))
+	baseSector = append(baseSector, layout.encode()...)
+	baseSector = append(baseSector, metadataBytes...)
+	baseSector = append(baseSector, content...)
+	return baseSector, nil
+}
@@ -0,0 +1,82 @@
+package skynet
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// TestSkyfileLayout tests encoding and decoding skyfile layouts.
+func TestSkyfileLayout(t *testing.T) {
+	layout := skyfileLayout{
+		version:            skyfileVersion,
+		filesize:           1 << 40,
+		metadataSize:       123,
+		fanoutSize:         456,
+		fanoutDataPieces:   1,
+		fanoutParityPieces: 10,
+		cipherType:         cipherTypePlain,
+	}
+	layout.keyData[63] = 7
+
+	encoded := layout.encode()
+	if len(encoded) != skyfileLayoutSize {
+		t.Fatalf("expected %v bytes, got %v", skyfileLayoutSize, len(encoded))
+	}
+	var decoded skyfileLayout
+	decoded.decode(encoded)
+	if decoded != layout {
+		t.Fatalf("expected layout %+v, got %+v", layout, decoded)
+	}
+}
+
+// TestParseBaseSector tests parsing the layout, metadata and content of a base
+// sector.
+func TestParseBaseSector(t *testing.T) {
+	content := []byte("test\n")
+	metadata, err := json.Marshal(SkyfileMetadata{Filename: "file1.txt", Length: uint64(len(content))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := skyfileLayout{
+		version:      skyfileVersion,
+		filesize:     uint64(len(content)),
+		metadataSize: uint64(len(metadata)),
+		cipherType:   cipherTypePlain,
+	}
+	baseSector := append(append(layout.encode(), metadata...), content...)
+	// Add some padding.
+	baseSector = append(baseSector, make([]byte, 100)...)
+
+	_, parsedMetadata, parsedContent, err := parseBaseSector(baseSector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsedMetadata.Filename != "file1.txt" || parsedMetadata.Length != uint64(len(content)) {
+		t.Fatalf("unexpected metadata %+v", parsedMetadata)
+	}
+	if !bytes.Equal(parsedContent, content) {
+		t.Fatalf("expected content %q, got %q", content, parsedContent)
+	}
+
+	// Test invalid base sectors.
+	encrypted := layout
+	encrypted.cipherType[7] = 4
+	tooLarge := layout
+	tooLarge.filesize = 1000
+	largeMetadata := layout
+	largeMetadata.metadataSize = 1 << 63
+	tests := [][]byte{
+		baseSector[:skyfileLayoutSize-1],
+		append([]byte{2}, baseSector[1:]...),
+		append(encrypted.encode(), baseSector[skyfileLayoutSize:]...),
+		append(tooLarge.encode(), baseSector[skyfileLayoutSize:]...),
+		append(largeMetadata.encode(), baseSector[skyfileLayoutSize:]...),
+		baseSector[:skyfileLayoutSize+len(metadata)-1],
+	}
+	for i, test := range tests {
+		if _, _, _, err := parseBaseSector(test); err == nil {
+			t.Fatalf("test %v: expected error", i)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package skynet
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gitlab.com/NebulousLabs/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+type (
+	// SkykeyRing is a collection of skykeys that is stored in a file encrypted
+	// with a passphrase. It allows keeping skykeys independently of the
+	// skykey manager of a portal and adding them to other portals with
+	// AddSkykey.
+	SkykeyRing struct {
+		skykeys []DecodedSkykey
+		mu      sync.Mutex
+	}
+
+	// skykeyRingFile is the content of a skykey ring file.
+	skykeyRingFile struct {
+		Version int `json:"version"`
+
+		// Salt, Time, Memory and Threads are the Argon2id parameters used to
+		// derive the encryption key from the passphrase.
+		Salt    []byte `json:"salt"`
+		Time    uint32 `json:"time"`
+		Memory  uint32 `json:"memory"`
+		Threads uint8  `json:"threads"`
+
+		// Ciphertext is the nonce followed by the encrypted
+		// skykeyRingData.
+		Ciphertext []byte `json:"ciphertext"`
+	}
+
+	// skykeyRingData is the encrypted data of a skykey ring file.
+	skykeyRingData struct {
+		Skykeys []skykeyRingSkykey `json:"skykeys"`
+	}
+
+	// skykeyRingSkykey is a skykey stored in a skykey ring file. The fields
+	// are stored separately so that any name is preserved.
+	skykeyRingSkykey struct {
+		Name    string `json:"name"`
+		Type    string `json:"type"`
+		Entropy []byte `json:"entropy"`
+	}
+)
+
+const (
+	// skykeyRingVersion is the version of the skykey ring file format.
+	skykeyRingVersion = 1
+
+	// skykeyRingSaltSize is the size of the salt used to derive the
+	// encryption key of skykey ring files.
+	skykeyRingSaltSize = 16
+
+	// skykeyRingTime, skykeyRingMemory and skykeyRingThreads are the Argon2id
+	// parameters used for new skykey ring files. The memory is in KiB.
+	skykeyRingTime    = 3
+	skykeyRingMemory  = 64 * 1024
+	skykeyRingThreads = 4
+
+	// skykeyRingMaxTime and skykeyRingMaxMemory are the highest Argon2id
+	// parameters accepted from skykey ring files, which limit the resources
+	// used to load a corrupted or malicious file. The memory is in KiB.
+	skykeyRingMaxTime   = 16
+	skykeyRingMaxMemory = 1024 * 1024
+)
+
+var (
+	// ErrSkykeyExists is returned when a skykey with the same ID or name is
+	// already in a skykey ring.
+	ErrSkykeyExists = errors.New("skykey with the same ID or name already exists")
+	// ErrSkykeyNotFound is returned when a skykey isn't in a skykey ring.
+	ErrSkykeyNotFound = errors.New("skykey not found")
+)
+
+// NewSkykeyRing returns an empty skykey ring.
+func NewSkykeyRing() *SkykeyRing {
+	return &SkykeyRing{}
+}
+
+// LoadSkykeyRing loads the skykey ring from the file, which was saved with the
+// given passphrase. ErrDecryption is returned if the passphrase is wrong.
+func LoadSkykeyRing(filename, passphrase string) (*SkykeyRing, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not read skykey ring file")
+	}
+	var file skykeyRingFile
+	err = json.Unmarshal(b, &file)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not unmarshal skykey ring file")
+	}
+	if file.Version != skykeyRingVersion {
+		return nil, fmt.Errorf("unsupported skykey ring file version %v", file.Version)
+	}
+	if file.Time == 0 || file.Time > skykeyRingMaxTime || file.Memory > skykeyRingMaxMemory || file.Threads == 0 {
+		return nil, errors.New("invalid key derivation parameters in skykey ring file")
+	}
+
+	aead, err := chacha20poly1305.NewX(skykeyRingKey(passphrase, file))
+	if err != nil {
+		return nil, errors.AddContext(err, "could not create cipher")
+	}
+	if len(file.Ciphertext) < aead.NonceSize() {
+		return nil, errors.AddContext(ErrDecryption, "skykey ring file is too short")
+	}
+	nonce, ciphertext := file.Ciphertext[:aead.NonceSize()], file.Ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.Compose(ErrDecryption, err)
+	}
+	var data skykeyRingData
+	err = json.Unmarshal(plaintext, &data)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not unmarshal skykeys")
+	}
+
+	kr := NewSkykeyRing()
+	for _, skykey := range data.Skykeys {
+		typeByte, ok := skykeyTypeByte(skykey.Type)
+		if !ok {
+			return nil, errors.AddContext(ErrInvalidSkykey, fmt.Sprintf("unsupported type %v", skykey.Type))
+		}
+		if len(skykey.Entropy) != skykeyEntropySize {
+			return nil, errors.AddContext(ErrInvalidSkykey, fmt.Sprintf("entropy has incorrect size %v", len(skykey.Entropy)))
+		}
+		if len(skykey.Name) > maxSkykeyNameSize {
+			return nil, errors.AddContext(ErrInvalidSkykey, "name is too long")
+		}
+		err = kr.Add(DecodedSkykey{
+			Name:    skykey.Name,
+			Type:    skykey.Type,
+			ID:      skykeyID(typeByte, skykey.Entropy),
+			Entropy: skykey.Entropy,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return kr, nil
+}
+
+// Save saves the skykey ring to the file, encrypted with the passphrase. The
+// file is replaced atomically, so it is never left partially written.
+func (kr *SkykeyRing) Save(filename, passphrase string) error {
+	kr.mu.Lock()
+	data := skykeyRingData{Skykeys: make([]skykeyRingSkykey, 0, len(kr.skykeys))}
+	for _, sk := range kr.skykeys {
+		data.Skykeys = append(data.Skykeys, skykeyRingSkykey{
+			Name:    sk.Name,
+			Type:    sk.Type,
+			Entropy: sk.Entropy,
+		})
+	}
+	kr.mu.Unlock()
+	plaintext, err := json.Marshal(data)
+	if err != nil {
+		return errors.AddContext(err, "could not marshal skykeys")
+	}
+
+	file := skykeyRingFile{
+		Version: skykeyRingVersion,
+		Salt:    make([]byte, skykeyRingSaltSize),
+		Time:    skykeyRingTime,
+		Memory:  skykeyRingMemory,
+		Threads: skykeyRingThreads,
+	}
+	_, err = rand.Read(file.Salt)
+	if err != nil {
+		return errors.AddContext(err, "could not generate salt")
+	}
+	aead, err := chacha20poly1305.NewX(skykeyRingKey(passphrase, file))
+	if err != nil {
+		return errors.AddContext(err, "could not create cipher")
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return errors.AddContext(err, "could not generate nonce")
+	}
+	file.Ciphertext = aead.Seal(nonce, nonce, plaintext, nil)
+	b, err := json.Marshal(file)
+	if err != nil {
+		return errors.AddContext(err, "could not marshal skykey ring file")
+	}
+
+	// Write to a temporary file in the same directory and rename it. The
+	// temporary file is only readable by the user.
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return errors.AddContext(err, "could not create temporary file")
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	err = errors.Compose(err, f.Close())
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		return errors.Compose(errors.AddContext(err, "could not write skykey ring file"), os.Remove(f.Name()))
+	}
+	return nil
+}
+
+// Add adds the skykey to the skykey ring. Like the skykey manager of skyd, a
+// skykey ring can't contain two skykeys with the same ID or name.
+func (kr *SkykeyRing) Add(sk DecodedSkykey) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	for _, existing := range kr.skykeys {
+		if existing.ID == sk.ID || existing.Name == sk.Name {
+			return errors.AddContext(ErrSkykeyExists, fmt.Sprintf("could not add skykey %v", sk.ID))
+		}
+	}
+	kr.skykeys = append(kr.skykeys, sk)
+	return nil
+}
+
+// Remove removes the skykey with the given ID from the skykey ring.
+func (kr *SkykeyRing) Remove(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	for i, sk := range kr.skykeys {
+		if sk.ID == id {
+			kr.skykeys = append(kr.skykeys[:i], kr.skykeys[i+1:]...)
+			return nil
+		}
+	}
+	return ErrSkykeyNotFound
+}
+
+// SkykeyByName returns the skykey with the given name.
+func (kr *SkykeyRing) SkykeyByName(name string) (DecodedSkykey, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	for _, sk := range kr.skykeys {
+		if sk.Name == name {
+			return sk, nil
+		}
+	}
+	return DecodedSkykey{}, ErrSkykeyNotFound
+}
+
+// SkykeyByID returns the skykey with the given ID.
+func (kr *SkykeyRing) SkykeyByID(id string) (DecodedSkykey, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	for _, sk := range kr.skykeys {
+		if sk.ID == id {
+			return sk, nil
+		}
+	}
+	return DecodedSkykey{}, ErrSkykeyNotFound
+}
+
+// Skykeys returns the skykeys of the skykey ring in the order they were
+// added.
+func (kr *SkykeyRing) Skykeys() []DecodedSkykey {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	return append([]DecodedSkykey(nil), kr.skykeys...)
+}
+
+// skykeyRingKey derives the encryption key of a skykey ring file from the
+// passphrase.
+func skykeyRingKey(passphrase string, file skykeyRingFile) []byte {
+	return argon2.IDKey([]byte(passphrase), file.Salt, file.Time, file.Memory, file.Threads, chacha20poly1305.KeySize)
+}
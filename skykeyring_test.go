@@ -0,0 +1,201 @@
+package skynet
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestSkykeyRing tests adding skykeys to a skykey ring and saving and loading
+// it.
+func TestSkykeyRing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skykeyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "skykeys")
+
+	sk1, err := GenSkykey("key1", SkykeyTypePublicID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk2, err := GenSkykey("key2", SkykeyTypePrivateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := NewSkykeyRing()
+	if err := kr.Add(sk1); err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.Add(sk2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Skykeys with the same ID or name can't be added.
+	sameName, err := GenSkykey("key1", SkykeyTypePublicID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.Add(sameName); !errors.Contains(err, ErrSkykeyExists) {
+		t.Fatalf("expected error %v, got %v", ErrSkykeyExists, err)
+	}
+	sameID := sk1
+	sameID.Name = "key3"
+	if err := kr.Add(sameID); !errors.Contains(err, ErrSkykeyExists) {
+		t.Fatalf("expected error %v, got %v", ErrSkykeyExists, err)
+	}
+
+	err = kr.Save(filename, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file shouldn't contain the skykeys in plaintext.
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte(sk1.ID)) || bytes.Contains(b, []byte("key1")) {
+		t.Fatal("skykey ring file contains skykeys in plaintext")
+	}
+
+	_, err = LoadSkykeyRing(filename, "wrong passphrase")
+	if !errors.Contains(err, ErrDecryption) {
+		t.Fatalf("expected error %v, got %v", ErrDecryption, err)
+	}
+	loaded, err := LoadSkykeyRing(filename, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	skykeys := loaded.Skykeys()
+	if len(skykeys) != 2 || skykeys[0].String() != sk1.String() || skykeys[1].String() != sk2.String() {
+		t.Fatalf("unexpected skykeys %+v", skykeys)
+	}
+	sk, err := loaded.SkykeyByName("key2")
+	if err != nil || sk.ID != sk2.ID {
+		t.Fatalf("expected skykey %v, got %v (%v)", sk2.ID, sk.ID, err)
+	}
+
+	// Removed skykeys shouldn't be found anymore.
+	if err := loaded.Remove(sk1.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.SkykeyByID(sk1.ID); !errors.Contains(err, ErrSkykeyNotFound) {
+		t.Fatalf("expected error %v, got %v", ErrSkykeyNotFound, err)
+	}
+	if err := loaded.Remove(sk1.ID); !errors.Contains(err, ErrSkykeyNotFound) {
+		t.Fatalf("expected error %v, got %v", ErrSkykeyNotFound, err)
+	}
+
+	// Saving again should replace the file without leaving temporary files.
+	err = loaded.Save(filename, "new passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = LoadSkykeyRing(filename, "new passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skykeys := loaded.Skykeys(); len(skykeys) != 1 || skykeys[0].ID != sk2.ID {
+		t.Fatalf("unexpected skykeys %+v", skykeys)
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 file, got %v", len(infos))
+	}
+}
+
+// TestSkykeyRingNames tests that the names of skykeys are preserved when a
+// skykey ring is saved and loaded.
+func TestSkykeyRingNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skykeyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "skykeys")
+
+	names := []string{"a+b", "a&b", "a#b", "a b", "100%", "x%zz", "y%zz", ""}
+	kr := NewSkykeyRing()
+	for _, name := range names {
+		sk, err := GenSkykey(name, SkykeyTypePrivateID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := kr.Add(sk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = kr.Save(filename, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSkykeyRing(filename, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	skykeys := loaded.Skykeys()
+	if len(skykeys) != len(names) {
+		t.Fatalf("expected %v skykeys, got %v", len(names), len(skykeys))
+	}
+	for i, sk := range kr.Skykeys() {
+		loadedSk := skykeys[i]
+		if loadedSk.Name != sk.Name || loadedSk.Type != sk.Type || loadedSk.ID != sk.ID || !bytes.Equal(loadedSk.Entropy, sk.Entropy) {
+			t.Fatalf("expected %+v, got %+v", sk, loadedSk)
+		}
+	}
+}
+
+// TestSkykeyRingParameters tests that skykey ring files with excessive key
+// derivation parameters are rejected.
+func TestSkykeyRingParameters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skykeyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "skykeys")
+	err = NewSkykeyRing().Save(filename, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file skykeyRingFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []func(file *skykeyRingFile){
+		func(file *skykeyRingFile) { file.Time = 0 },
+		func(file *skykeyRingFile) { file.Time = skykeyRingMaxTime + 1 },
+		func(file *skykeyRingFile) { file.Memory = skykeyRingMaxMemory + 1 },
+		func(file *skykeyRingFile) { file.Memory = 4294967295 },
+		func(file *skykeyRingFile) { file.Threads = 0 },
+	}
+	for i, tamper := range tests {
+		tampered := file
+		tamper(&tampered)
+		b, err := json.Marshal(tampered)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err = LoadSkykeyRing(filename, "passphrase")
+		if err == nil {
+			t.Fatalf("test %v: expected error for parameters %+v", i, tampered)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package skynet
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"math/bits"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// Skylink is a link to data stored on Skynet. It consists of a 2 byte
+	// bitfield and a 32 byte hash.
+	//
+	// The first two bits of the bitfield determine the version of the
+	// skylink. For v1 skylinks, the remaining bits encode the offset and fetch
+	// size of the data within a sector, and the hash is the merkle root of
+	// that sector. For v2 skylinks, the hash is the ID of a registry entry
+	// containing another skylink.
+	Skylink struct {
+		bitfield   uint16
+		merkleRoot [32]byte
+	}
+)
+
+const (
+	// SkylinkMaxFetchSize is the maximum fetch size supported by the skylink
+	// format, which is the size of a sector.
+	SkylinkMaxFetchSize = 1 << 22
+
+	// base32EncodedSkylinkSize is the size of a base32 encoded skylink.
+	base32EncodedSkylinkSize = 55
+	// base64EncodedSkylinkSize is the size of a base64 encoded skylink.
+	base64EncodedSkylinkSize = 46
+	// rawSkylinkSize is the size of a raw skylink.
+	rawSkylinkSize = 34
+)
+
+var (
+	// ErrSkylinkIncorrectSize is returned when a string could not be parsed
+	// as a skylink due to it having an incorrect size.
+	ErrSkylinkIncorrectSize = errors.New("skylink has incorrect size")
+
+	// ErrMalformedSkylink is returned when a skylink could not be parsed.
+	ErrMalformedSkylink = errors.New("skylink is malformed")
+
+	// base32Encoding is the encoding used for base32 skylinks.
+	base32Encoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+)
+
+// NewSkylinkV1 returns a v1 skylink for the data at the given offset within
+// the sector with the given merkle root. The fetch size is rounded up to the
+// nearest fetch size supported by the format, and the offset has to be aligned
+// according to the fetch size.
+func NewSkylinkV1(merkleRoot [32]byte, offset, fetchSize uint64) (Skylink, error) {
+	bitfield, err := makeV1Bitfield(offset, fetchSize)
+	if err != nil {
+		return Skylink{}, errors.AddContext(err, "invalid skylink")
+	}
+	return Skylink{bitfield: bitfield, merkleRoot: merkleRoot}, nil
+}
+
+// ParseSkylink parses a base64 or base32 encoded skylink, with or without the
+// Skynet URI prefix.
+func ParseSkylink(s string) (Skylink, error) {
+	s = strings.TrimPrefix(s, URISkynetPrefix)
+
+	var raw []byte
+	var err error
+	switch len(s) {
+	case base32EncodedSkylinkSize:
+		raw, err = base32Encoding.DecodeString(strings.ToUpper(s))
+	case base64EncodedSkylinkSize:
+		raw, err = base64.RawURLEncoding.DecodeString(s)
+	default:
+		return Skylink{}, errors.Compose(ErrMalformedSkylink, ErrSkylinkIncorrectSize)
+	}
+	if err != nil {
+		return Skylink{}, errors.Compose(ErrMalformedSkylink, errors.AddContext(err, "could not decode skylink"))
+	}
+	return SkylinkFromBytes(raw)
+}
+
+// SkylinkFromBytes returns the skylink with the given raw representation.
+func SkylinkFromBytes(raw []byte) (Skylink, error) {
+	if len(raw) != rawSkylinkSize {
+		return Skylink{}, errors.Compose(ErrMalformedSkylink, ErrSkylinkIncorrectSize)
+	}
+
+	var sl Skylink
+	sl.bitfield = binary.LittleEndian.Uint16(raw)
+	copy(sl.merkleRoot[:], raw[2:])
+	switch {
+	case sl.IsSkylinkV1():
+		_, _, err := sl.OffsetAndFetchSize()
+		if err != nil {
+			return Skylink{}, errors.Compose(ErrMalformedSkylink, err)
+		}
+	case sl.IsSkylinkV2():
+	default:
+		return Skylink{}, errors.Compose(ErrMalformedSkylink, errors.New("unknown skylink version"))
+	}
+	return sl, nil
+}
+
+// Base32EncodedString returns the base32 encoding of the skylink, as used in
+// subdomains.
+func (sl Skylink) Base32EncodedString() string {
+	return strings.ToLower(base32Encoding.EncodeToString(sl.Bytes()))
+}
+
+// Bitfield returns the bitfield of the skylink.
+func (sl Skylink) Bitfield() uint16 {
+	return sl.bitfield
+}
+
+// Bytes returns the raw representation of the skylink.
+func (sl Skylink) Bytes() []byte {
+	raw := make([]byte, rawSkylinkSize)
+	binary.LittleEndian.PutUint16(raw, sl.bitfield)
+	copy(raw[2:], sl.merkleRoot[:])
+	return raw
+}
+
+// IsSkylinkV1 returns true if the skylink is a v1 skylink.
+func (sl Skylink) IsSkylinkV1() bool {
+	return sl.bitfield&3 == 0
+}
+
+// IsSkylinkV2 returns true if the skylink is a v2 skylink. A v2 skylink only
+// uses the version bits of the bitfield.
+func (sl Skylink) IsSkylinkV2() bool {
+	return sl.bitfield == 1
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (sl Skylink) MarshalText() ([]byte, error) {
+	return []byte(sl.String()), nil
+}
+
+// MerkleRoot returns the hash of the skylink. For v1 skylinks this is the
+// merkle root of the sector containing the data, for v2 skylinks it is the ID
+// of the registry entry the skylink points to.
+func (sl Skylink) MerkleRoot() [32]byte {
+	return sl.merkleRoot
+}
+
+// OffsetAndFetchSize returns the offset and fetch size of the data within the
+// sector of a v1 skylink.
+func (sl Skylink) OffsetAndFetchSize() (offset uint64, fetchSize uint64, err error) {
+	return parseV1Bitfield(sl.bitfield)
+}
+
+// String returns the base64 encoding of the skylink.
+func (sl Skylink) String() string {
+	return base64.RawURLEncoding.EncodeToString(sl.Bytes())
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (sl *Skylink) UnmarshalText(text []byte) error {
+	parsed, err := ParseSkylink(string(text))
+	if err != nil {
+		return err
+	}
+	*sl = parsed
+	return nil
+}
+
+// URI returns the skylink with the Skynet URI prefix.
+func (sl Skylink) URI() string {
+	return URISkynetPrefix + sl.String()
+}
+
+// Version returns the version of the skylink, in the range [1, 4].
+func (sl Skylink) Version() int {
+	return int(sl.bitfield&3) + 1
+}
+
+// makeV1Bitfield returns the v1 bitfield for the given offset and fetch size.
+// See parseV1Bitfield for a description of the format.
+func makeV1Bitfield(offset, fetchSize uint64) (uint16, error) {
+	if offset+fetchSize > SkylinkMaxFetchSize {
+		return 0, errors.New("offset plus fetch size cannot exceed the size of one sector - 4 MiB")
+	}
+
+	// Determine the offset alignment for the fetch size. The largest offset
+	// alignment is 512 KiB, which is used for fetch sizes over 2 MiB. Each
+	// time the fetch size is halved, the alignment is also halved, down to 4
+	// KiB.
+	minFetchSize := uint64(1 << 21)
+	offsetAlign := uint64(1 << 19)
+	for fetchSize <= minFetchSize && offsetAlign > 1<<12 {
+		offsetAlign >>= 1
+		minFetchSize >>= 1
+	}
+	if offset&(offsetAlign-1) != 0 {
+		return 0, errors.New("offset is not aligned correctly")
+	}
+	bitwiseOffset := uint16(offset / offsetAlign)
+
+	// The fetch size alignment is half the offset alignment, except for the
+	// first mode where both are 4 KiB. All modes but the first start after
+	// the 8 fetch sizes of the previous mode.
+	fetchSizeAlign := uint64(1 << 12)
+	if offsetAlign > 1<<13 {
+		fetchSizeAlign = offsetAlign >> 1
+	}
+	if offsetAlign > 1<<12 {
+		fetchSize -= fetchSizeAlign * 8
+	}
+	// Round the fetch size up. The fetch sizes are shifted from [0, 8) to [1,
+	// 8], so the rounding is done by decrementing and rounding down.
+	if fetchSize != 0 {
+		fetchSize--
+	}
+	bitwiseFetchSize := uint16(fetchSize / fetchSizeAlign)
+
+	// The bitfield contains, from the highest to the lowest bits, the offset,
+	// the fetch size, a 0 bit terminating the mode bits, a 1 bit for every
+	// mode after the first and two 0 bits for version 1.
+	bitfield := bitwiseOffset<<3 + bitwiseFetchSize
+	bitfield <<= 1
+	for align := uint64(1 << 12); align < offsetAlign; align <<= 1 {
+		bitfield = bitfield<<1 + 1
+	}
+	bitfield <<= 2
+	return bitfield, nil
+}
+
+// parseV1Bitfield validates a v1 bitfield and returns the offset and fetch size
+// it encodes.
+//
+// After the two version bits, up to 7 mode bits set to 1 and a terminating 0
+// bit select one of 8 modes. In the first mode, the offset is aligned to 4 KiB
+// and the fetch size is one of 8 multiples of 4 KiB. Every further mode doubles
+// the offset alignment and, starting at the third mode, the fetch size
+// increment, with fetch sizes continuing where the previous mode left off. The
+// next 3 bits select the fetch size and the remaining bits the offset.
+func parseV1Bitfield(bitfield uint16) (offset uint64, fetchSize uint64, err error) {
+	if bitfield&3 != 0 {
+		return 0, SkylinkMaxFetchSize, errors.New("bitfield is not set to v1")
+	}
+	bitfield >>= 2
+
+	// At least one of the 8 mode bits has to be 0.
+	if bitfield&255 == 255 {
+		return 0, SkylinkMaxFetchSize, errors.New("skylink is not valid, offset and fetch size are illegal")
+	}
+	modeBits := uint16(bits.TrailingZeros16(^bitfield))
+	bitfield >>= modeBits + 1
+
+	offsetAlign := uint64(4096) << modeBits
+	fetchSizeAlign := uint64(4096)
+	if modeBits > 0 {
+		fetchSizeAlign <<= modeBits - 1
+	}
+
+	fetchSize = (uint64(bitfield&7) + 1) * fetchSizeAlign
+	if modeBits > 0 {
+		fetchSize += fetchSizeAlign << 3
+	}
+	bitfield >>= 3
+
+	offset = uint64(bitfield) * offsetAlign
+	if offset+fetchSize > SkylinkMaxFetchSize {
+		return 0, SkylinkMaxFetchSize, errors.New("invalid bitfield, fetching beyond the limits of the sector")
+	}
+	return offset, fetchSize, nil
+}
@@ -0,0 +1,155 @@
+package skynet
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestParseSkylink tests parsing base64 and base32 encoded skylinks.
+func TestParseSkylink(t *testing.T) {
+	const skylinkBase64 = "XABvi7JtJbQSMAcDwnUnmp2FKDPjg8_tTTFP4BwMSxVdEg"
+
+	sl, err := ParseSkylink(skylinkBase64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl.String() != skylinkBase64 {
+		t.Fatalf("expected skylink %v, got %v", skylinkBase64, sl.String())
+	}
+	if sl.URI() != URISkynetPrefix+skylinkBase64 {
+		t.Fatalf("expected URI %v, got %v", URISkynetPrefix+skylinkBase64, sl.URI())
+	}
+	if sl.Version() != 1 || !sl.IsSkylinkV1() || sl.IsSkylinkV2() {
+		t.Fatalf("expected v1 skylink, got version %v", sl.Version())
+	}
+	offset, fetchSize, err := sl.OffsetAndFetchSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 0 || fetchSize != 163840 {
+		t.Fatalf("expected offset %v and fetch size %v, got %v and %v", 0, 163840, offset, fetchSize)
+	}
+
+	// The base32 encoding and the URI should parse to the same skylink.
+	base32 := sl.Base32EncodedString()
+	if len(base32) != base32EncodedSkylinkSize {
+		t.Fatalf("expected base32 skylink of length %v, got %v", base32EncodedSkylinkSize, len(base32))
+	}
+	for _, s := range []string{base32, URISkynetPrefix + skylinkBase64} {
+		sl2, err := ParseSkylink(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sl2 != sl {
+			t.Fatalf("%v: expected skylink %v, got %v", s, sl, sl2)
+		}
+	}
+
+	// Test invalid skylinks.
+	v2 := Skylink{bitfield: 1, merkleRoot: sl.MerkleRoot()}
+	tests := []string{
+		"",
+		skylinkBase64[:45],
+		skylinkBase64 + "A",
+		skylinkBase64[:45] + "!",
+		// Unknown versions.
+		Skylink{bitfield: 2}.String(),
+		Skylink{bitfield: 3}.String(),
+		// A v2 skylink with bits set besides the version.
+		Skylink{bitfield: 5}.String(),
+		// All mode bits set.
+		Skylink{bitfield: 255 << 2}.String(),
+	}
+	for _, test := range tests {
+		_, err := ParseSkylink(test)
+		if !errors.Contains(err, ErrMalformedSkylink) {
+			t.Fatalf("%q: expected error %v, got %v", test, ErrMalformedSkylink, err)
+		}
+	}
+	sl2, err := ParseSkylink(v2.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl2.Version() != 2 || !sl2.IsSkylinkV2() {
+		t.Fatalf("expected v2 skylink, got version %v", sl2.Version())
+	}
+}
+
+// TestNewSkylinkV1 tests that the offset and fetch size of v1 skylinks
+// round-trip through the bitfield.
+func TestNewSkylinkV1(t *testing.T) {
+	var merkleRoot [32]byte
+	merkleRoot[0] = 1
+
+	for fetchSize := uint64(1); fetchSize <= SkylinkMaxFetchSize; fetchSize += 4093 {
+		// Find the offset alignment by checking the smallest valid offset.
+		var offsets []uint64
+		for align := uint64(1 << 12); align <= 1<<19; align <<= 1 {
+			if _, err := NewSkylinkV1(merkleRoot, align, fetchSize); err == nil {
+				offsets = append(offsets, 0, align, SkylinkMaxFetchSize-fetchSize-(SkylinkMaxFetchSize-fetchSize)%align)
+				break
+			}
+		}
+		if len(offsets) == 0 {
+			offsets = []uint64{0}
+		}
+
+		for _, offset := range offsets {
+			sl, err := NewSkylinkV1(merkleRoot, offset, fetchSize)
+			if err != nil {
+				t.Fatalf("offset %v, fetch size %v: %v", offset, fetchSize, err)
+			}
+			parsedOffset, parsedFetchSize, err := sl.OffsetAndFetchSize()
+			if err != nil {
+				t.Fatalf("offset %v, fetch size %v: %v", offset, fetchSize, err)
+			}
+			if parsedOffset != offset {
+				t.Fatalf("expected offset %v, got %v", offset, parsedOffset)
+			}
+			// The fetch size is rounded up by less than 1/8th.
+			if parsedFetchSize < fetchSize || parsedFetchSize-fetchSize > fetchSize/8+4096 {
+				t.Fatalf("fetch size %v rounded to %v", fetchSize, parsedFetchSize)
+			}
+			if sl.MerkleRoot() != merkleRoot {
+				t.Fatal("merkle root mismatch")
+			}
+		}
+	}
+
+	// Test invalid offsets and fetch sizes.
+	if _, err := NewSkylinkV1(merkleRoot, 1, 4096); err == nil {
+		t.Fatal("expected unaligned offset to fail")
+	}
+	if _, err := NewSkylinkV1(merkleRoot, 4096, SkylinkMaxFetchSize); err == nil {
+		t.Fatal("expected fetching beyond the sector to fail")
+	}
+}
+
+// TestSkylinkJSON tests encoding skylinks as JSON.
+func TestSkylinkJSON(t *testing.T) {
+	sl, err := ParseSkylink("XABvi7JtJbQSMAcDwnUnmp2FKDPjg8_tTTFP4BwMSxVdEg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(sl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte(`"XABvi7JtJbQSMAcDwnUnmp2FKDPjg8_tTTFP4BwMSxVdEg"`)) {
+		t.Fatalf("unexpected JSON %s", data)
+	}
+	var sl2 Skylink
+	err = json.Unmarshal(data, &sl2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl2 != sl {
+		t.Fatalf("expected skylink %v, got %v", sl, sl2)
+	}
+	if err := json.Unmarshal([]byte(`"invalid"`), &sl2); err == nil {
+		t.Fatal("expected invalid skylink to fail")
+	}
+}
@@ -0,0 +1,557 @@
+package skynet
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// SubscribeEntryOptions contains the options used for subscribing to
+	// registry entries.
+	SubscribeEntryOptions struct {
+		Options
+
+		// NotificationDelay is the time the portal waits between
+		// notifications. The portal's default is used if it is 0.
+		NotificationDelay time.Duration
+		// ReconnectPolicy configures the backoff between attempts to
+		// reconnect after the connection to the portal was lost. MaxAttempts
+		// is the maximum number of consecutive failed attempts, after which
+		// all subscriptions are ended. A value of 0 or less retries
+		// indefinitely.
+		ReconnectPolicy RetryPolicy
+		// PingInterval is the time between pings sent to the portal to
+		// detect lost connections. The connection is considered lost if
+		// neither a message nor a pong was received for twice the interval.
+		// Pings are disabled if it is 0.
+		PingInterval time.Duration
+		// OnError is called with the errors of the subscription that don't
+		// end it, such as error notifications from the portal and entries
+		// that don't verify. Unlike the other options, it only applies to the
+		// subscription that is made with it. It is called from the goroutine
+		// reading from the connection, so it should return quickly.
+		OnError func(err error)
+	}
+
+	// registrySubscriptions manages the registry subscriptions of a client,
+	// which share a websocket connection to the portal. The options of the
+	// subscription that opened the connection are used for it.
+	registrySubscriptions struct {
+		conn *websocket.Conn
+		opts SubscribeEntryOptions
+		subs map[revisionCacheKey]*entrySubscription
+		mu   sync.Mutex
+	}
+
+	// entrySubscription is the subscription to a single registry entry.
+	entrySubscription struct {
+		publicKey ed25519.PublicKey
+		dataKey   string
+
+		// revision is the revision of the last delivered entry. It is only
+		// valid if delivered is set. Both fields are only accessed by the
+		// goroutine reading from the connection.
+		revision  uint64
+		delivered bool
+
+		// onError is called with the errors of the subscription, if set.
+		onError func(err error)
+
+		// updates receives the entries. It is closed once the subscription
+		// ended and sendMu is held while an entry is sent.
+		updates chan SignedRegistryEntry
+		done    chan struct{}
+		sendMu  sync.Mutex
+	}
+
+	// registrySubscriptionRequest is a message sent to the portal to
+	// subscribe to or unsubscribe from a registry entry.
+	registrySubscriptionRequest struct {
+		Action    string            `json:"action"`
+		PublicKey registryPublicKey `json:"pubkey"`
+		DataKey   string            `json:"datakey"`
+	}
+
+	// registrySubscriptionNotification is a message sent by the portal when a
+	// subscribed registry entry was updated or an error occurred.
+	registrySubscriptionNotification struct {
+		Error     string            `json:"error,omitempty"`
+		PublicKey registryPublicKey `json:"pubkey"`
+		DataKey   string            `json:"datakey"`
+		Revision  uint64            `json:"revision"`
+		Signature [64]byte          `json:"signature"`
+		Data      []byte            `json:"data"`
+		Type      RegistryEntryType `json:"type"`
+	}
+)
+
+const (
+	// registrySubscriptionActionSubscribe and
+	// registrySubscriptionActionUnsubscribe are the actions of subscription
+	// requests.
+	registrySubscriptionActionSubscribe   = "subscribe"
+	registrySubscriptionActionUnsubscribe = "unsubscribe"
+
+	// subscriptionUpdatesBuffer is the number of entries buffered for each
+	// subscription.
+	subscriptionUpdatesBuffer = 16
+
+	// subscriptionWriteTimeout is the timeout for writing a message to the
+	// portal.
+	subscriptionWriteTimeout = 10 * time.Second
+)
+
+var (
+	// DefaultSubscribeEntryOptions contains the default options for
+	// subscribing to registry entries.
+	DefaultSubscribeEntryOptions = SubscribeEntryOptions{
+		Options:           DefaultOptions("/skynet/registry/subscription"),
+		NotificationDelay: 0,
+		ReconnectPolicy: RetryPolicy{
+			MaxAttempts:    0,
+			InitialBackoff: time.Second,
+			MaxBackoff:     30 * time.Second,
+		},
+		PingInterval: 30 * time.Second,
+	}
+
+	// ErrAlreadySubscribed is returned when subscribing to a registry entry
+	// that the client is already subscribed to.
+	ErrAlreadySubscribed = errors.New("already subscribed to registry entry")
+	// ErrNotSubscribed is returned when unsubscribing from a registry entry
+	// that the client isn't subscribed to.
+	ErrNotSubscribed = errors.New("not subscribed to registry entry")
+	// ErrSubscriptionNotification is passed to the OnError option of
+	// subscriptions when the portal sent an error notification, along with
+	// the error message of the portal.
+	ErrSubscriptionNotification = errors.New("portal sent an error notification")
+	// ErrSubscriptionEnded is passed to the OnError option of subscriptions,
+	// along with the last connection error, when they are ended because the
+	// portal couldn't be reached with the maximum number of attempts of the
+	// reconnect policy.
+	ErrSubscriptionEnded = errors.New("subscription ended after failing to reconnect")
+)
+
+// SubscribeEntry subscribes to the registry entry of the given public key and
+// data key. Verified updates of the entry are sent on the returned channel
+// until Unsubscribe is called, which closes the channel. If the channel isn't
+// drained, older updates are dropped in favour of newer ones. Lost connections
+// to the portal are reestablished in the background. If that fails, the
+// channel is closed and ErrSubscriptionEnded is passed to OnError. The HTTP
+// client of the options has to use an *http.Transport, as only its proxy and
+// TLS settings apply to the websocket connection.
+func (sc *SkynetClient) SubscribeEntry(publicKey ed25519.PublicKey, dataKey string, opts SubscribeEntryOptions) (<-chan SignedRegistryEntry, error) {
+	return sc.SubscribeEntryWithContext(context.Background(), publicKey, dataKey, opts)
+}
+
+// SubscribeEntryWithContext subscribes to the registry entry of the given
+// public key and data key. Verified updates of the entry are sent on the
+// returned channel until Unsubscribe is called, which closes the channel. If
+// the channel isn't drained, older updates are dropped in favour of newer ones.
+// The context only applies to connecting to the portal, lost connections are
+// reestablished in the background. If that fails, the channel is closed and
+// ErrSubscriptionEnded is passed to OnError. The HTTP client of the options has
+// to use an *http.Transport, as only its proxy and TLS settings apply to the
+// websocket connection.
+func (sc *SkynetClient) SubscribeEntryWithContext(ctx context.Context, publicKey ed25519.PublicKey, dataKey string, opts SubscribeEntryOptions) (<-chan SignedRegistryEntry, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key has incorrect size %v", len(publicKey))
+	}
+	rs := sc.subscriptions
+	if rs == nil {
+		return nil, errors.New("subscriptions require a client created with New or NewCustom")
+	}
+	key := revisionCacheKey{publicKey: string(publicKey), hashedDataKey: HashDataKey(dataKey)}
+	sub := &entrySubscription{
+		publicKey: publicKey,
+		dataKey:   dataKey,
+		onError:   opts.OnError,
+		updates:   make(chan SignedRegistryEntry, subscriptionUpdatesBuffer),
+		done:      make(chan struct{}),
+	}
+
+	// Connect to the portal without holding the lock, so that other
+	// subscriptions aren't blocked by a slow portal.
+	var conn *websocket.Conn
+	rs.mu.Lock()
+	for {
+		if _, ok := rs.subs[key]; ok {
+			rs.mu.Unlock()
+			if conn != nil {
+				_ = conn.Close()
+			}
+			return nil, ErrAlreadySubscribed
+		}
+		if rs.conn != nil || conn != nil {
+			break
+		}
+		rs.mu.Unlock()
+		var err error
+		conn, err = sc.dialSubscriptions(ctx, opts)
+		if err != nil {
+			return nil, errors.AddContext(err, "could not connect to portal")
+		}
+		rs.mu.Lock()
+	}
+	defer rs.mu.Unlock()
+	if rs.conn != nil && conn != nil {
+		// Another subscription connected in the meantime.
+		_ = conn.Close()
+	} else if rs.conn == nil {
+		rs.conn = conn
+		rs.opts = opts
+		go sc.readSubscriptions(conn, opts)
+		// Resubscribe to the entries that are waiting for a reconnect.
+		for _, sub := range rs.subs {
+			_ = rs.send(registrySubscriptionActionSubscribe, sub)
+		}
+	}
+	err := rs.send(registrySubscriptionActionSubscribe, sub)
+	if err != nil {
+		return nil, errors.AddContext(err, "could not subscribe to registry entry")
+	}
+	rs.subs[key] = sub
+	return sub.updates, nil
+}
+
+// Unsubscribe ends the subscription to the registry entry of the given public
+// key and data key and closes its channel. The connection to the portal is
+// closed once there are no subscriptions left.
+func (sc *SkynetClient) Unsubscribe(publicKey ed25519.PublicKey, dataKey string) error {
+	rs := sc.subscriptions
+	if rs == nil {
+		return ErrNotSubscribed
+	}
+	key := revisionCacheKey{publicKey: string(publicKey), hashedDataKey: HashDataKey(dataKey)}
+
+	rs.mu.Lock()
+	sub, ok := rs.subs[key]
+	if !ok {
+		rs.mu.Unlock()
+		return ErrNotSubscribed
+	}
+	delete(rs.subs, key)
+	var err error
+	if rs.conn != nil {
+		if len(rs.subs) == 0 {
+			err = rs.conn.Close()
+			rs.conn = nil
+		} else {
+			err = rs.send(registrySubscriptionActionUnsubscribe, sub)
+		}
+	}
+	rs.mu.Unlock()
+
+	sub.close()
+	return errors.AddContext(err, "could not unsubscribe from registry entry")
+}
+
+// dialSubscriptions opens a websocket connection to the registry subscription
+// endpoint of the portal.
+func (sc *SkynetClient) dialSubscriptions(ctx context.Context, opts SubscribeEntryOptions) (*websocket.Conn, error) {
+	options := sc.mergeOptions(opts.Options)
+
+	query := url.Values{}
+	if opts.NotificationDelay > 0 {
+		query.Set("notificationdelay", strconv.FormatInt(int64(opts.NotificationDelay/time.Millisecond), 10))
+	}
+	wsURL := makeURL(sc.PortalURL, options.EndpointPath, "", query)
+	if strings.HasPrefix(wsURL, "https://") {
+		wsURL = "wss://" + strings.TrimPrefix(wsURL, "https://")
+	} else {
+		wsURL = "ws://" + strings.TrimPrefix(wsURL, "http://")
+	}
+
+	// Use the TLS and proxy settings of the HTTP client. Other round
+	// trippers can't be used for websocket connections.
+	roundTripper := options.httpClient().Transport
+	if roundTripper == nil {
+		roundTripper = http.DefaultTransport
+	}
+	transport, ok := roundTripper.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("subscriptions require an HTTP client with an *http.Transport, not %T", roundTripper)
+	}
+	dialer := *websocket.DefaultDialer
+	dialer.Proxy = transport.Proxy
+	if transport.TLSClientConfig != nil {
+		dialer.TLSClientConfig = transport.TLSClientConfig.Clone()
+	}
+	if dialer.TLSClientConfig == nil {
+		dialer.TLSClientConfig = &tls.Config{}
+	}
+
+	header := http.Header{}
+	setOptionHeaders(header, options)
+	conn, resp, err := dialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		if resp != nil {
+			err = errors.Compose(err, fmt.Errorf("portal responded with status %v", resp.StatusCode))
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// readSubscriptions reads notifications from the connection until it is
+// closed, and reconnects if the connection was lost. Messages that can't be
+// decoded are reported to the subscriptions and don't affect the connection.
+func (sc *SkynetClient) readSubscriptions(conn *websocket.Conn, opts SubscribeEntryOptions) {
+	rs := sc.subscriptions
+	// Consider the connection lost if neither messages nor pongs are
+	// received, as half-open connections would block reads forever.
+	readTimeout := 2 * opts.PingInterval
+	if opts.PingInterval > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(readTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(readTimeout))
+		})
+		stop := make(chan struct{})
+		defer close(stop)
+		go keepAlive(conn, opts.PingInterval, stop)
+	}
+	for {
+		_, r, err := conn.NextReader()
+		if err != nil {
+			break
+		}
+		if opts.PingInterval > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(readTimeout))
+		}
+		var notification registrySubscriptionNotification
+		err = json.NewDecoder(r).Decode(&notification)
+		if err != nil {
+			rs.reportError(errors.AddContext(err, "could not decode notification"))
+			continue
+		}
+		rs.mu.Lock()
+		sub, ok := rs.subs[notification.key()]
+		rs.mu.Unlock()
+		if notification.Error != "" {
+			// Errors that don't belong to a subscription are reported to
+			// all of them.
+			err := errors.Extend(ErrSubscriptionNotification, errors.New(notification.Error))
+			if ok {
+				sub.reportError(err)
+			} else {
+				rs.reportError(err)
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+		entry, err := notification.decode(sub.dataKey)
+		if err == nil {
+			err = entry.Verify(sub.publicKey)
+		}
+		if err != nil {
+			sub.reportError(errors.AddContext(err, "portal sent an invalid registry entry"))
+			continue
+		}
+		// Skip entries that were already delivered, which are sent again
+		// after resubscribing.
+		if sub.delivered && entry.Entry.Revision <= sub.revision {
+			continue
+		}
+		sub.revision = entry.Entry.Revision
+		sub.delivered = true
+		sc.revisionCache().update(sub.publicKey, sub.dataKey, entry.Entry.Revision)
+		sub.send(entry)
+	}
+
+	rs.mu.Lock()
+	if rs.conn != conn {
+		// The connection was closed by Unsubscribe.
+		rs.mu.Unlock()
+		return
+	}
+	_ = conn.Close()
+	rs.conn = nil
+	rs.mu.Unlock()
+	sc.reconnectSubscriptions(opts)
+}
+
+// reconnectSubscriptions reconnects to the portal with backoff and
+// resubscribes to all entries. The subscriptions are ended if the portal can't
+// be reached with the maximum number of attempts of the reconnect policy.
+func (sc *SkynetClient) reconnectSubscriptions(opts SubscribeEntryOptions) {
+	rs := sc.subscriptions
+	policy := opts.ReconnectPolicy
+	var lastErr error
+	for attempt := 1; policy.MaxAttempts <= 0 || attempt <= policy.MaxAttempts; attempt++ {
+		time.Sleep(policy.backoff(attempt))
+		if rs.reconnected() {
+			return
+		}
+		conn, err := sc.dialSubscriptions(context.Background(), opts)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		rs.mu.Lock()
+		if len(rs.subs) == 0 || rs.conn != nil {
+			rs.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		rs.conn = conn
+		// Failed requests are noticed by the reader, which reconnects again.
+		for _, sub := range rs.subs {
+			_ = rs.send(registrySubscriptionActionSubscribe, sub)
+		}
+		rs.mu.Unlock()
+		go sc.readSubscriptions(conn, opts)
+		return
+	}
+
+	// Give up and end all subscriptions, telling the subscribers why.
+	rs.mu.Lock()
+	subs := rs.subs
+	rs.subs = make(map[revisionCacheKey]*entrySubscription)
+	rs.mu.Unlock()
+	err := errors.Compose(ErrSubscriptionEnded, lastErr)
+	for _, sub := range subs {
+		sub.reportError(err)
+		sub.close()
+	}
+}
+
+// keepAlive pings the portal at the given interval until stop is closed. The
+// pongs extend the read deadline of the connection.
+func keepAlive(conn *websocket.Conn, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		// Failed pings are noticed by the reader through the read deadline.
+		_ = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(subscriptionWriteTimeout))
+	}
+}
+
+// newRegistrySubscriptions returns a registrySubscriptions without any
+// subscriptions.
+func newRegistrySubscriptions() *registrySubscriptions {
+	return &registrySubscriptions{
+		subs: make(map[revisionCacheKey]*entrySubscription),
+	}
+}
+
+// reconnected returns whether reconnecting is no longer necessary, because
+// there are no subscriptions left or a new subscription connected already.
+func (rs *registrySubscriptions) reconnected() bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return len(rs.subs) == 0 || rs.conn != nil
+}
+
+// reportError reports the error to all subscriptions.
+func (rs *registrySubscriptions) reportError(err error) {
+	rs.mu.Lock()
+	subs := make([]*entrySubscription, 0, len(rs.subs))
+	for _, sub := range rs.subs {
+		subs = append(subs, sub)
+	}
+	rs.mu.Unlock()
+	for _, sub := range subs {
+		sub.reportError(err)
+	}
+}
+
+// send sends a subscription request for the entry to the portal. The caller
+// has to hold the lock and a connection has to be open.
+func (rs *registrySubscriptions) send(action string, sub *entrySubscription) error {
+	hashedDataKey := HashDataKey(sub.dataKey)
+	req := registrySubscriptionRequest{
+		Action: action,
+		PublicKey: registryPublicKey{
+			Algorithm: "ed25519",
+			Key:       sub.publicKey,
+		},
+		DataKey: hex.EncodeToString(hashedDataKey[:]),
+	}
+	err := rs.conn.SetWriteDeadline(time.Now().Add(subscriptionWriteTimeout))
+	if err != nil {
+		return err
+	}
+	return rs.conn.WriteJSON(req)
+}
+
+// send delivers the entry to the subscriber, dropping the oldest undelivered
+// entry if the channel is full.
+func (sub *entrySubscription) send(entry SignedRegistryEntry) {
+	sub.sendMu.Lock()
+	defer sub.sendMu.Unlock()
+	for {
+		select {
+		case <-sub.done:
+			return
+		default:
+		}
+		select {
+		case sub.updates <- entry:
+			return
+		default:
+		}
+		select {
+		case <-sub.updates:
+		default:
+		}
+	}
+}
+
+// reportError passes the error to the OnError option of the subscription, if
+// it was set.
+func (sub *entrySubscription) reportError(err error) {
+	if sub.onError != nil {
+		sub.onError(err)
+	}
+}
+
+// close ends the subscription and closes its channel.
+func (sub *entrySubscription) close() {
+	close(sub.done)
+	sub.sendMu.Lock()
+	defer sub.sendMu.Unlock()
+	close(sub.updates)
+}
+
+// key returns the key of the subscription the notification belongs to.
+func (rsn registrySubscriptionNotification) key() revisionCacheKey {
+	key := revisionCacheKey{publicKey: string(rsn.PublicKey.Key)}
+	hashedDataKey, err := hex.DecodeString(rsn.DataKey)
+	if err == nil {
+		copy(key.hashedDataKey[:], hashedDataKey)
+	}
+	return key
+}
+
+// decode converts the notification into a signed registry entry with the given
+// data key.
+func (rsn registrySubscriptionNotification) decode(dataKey string) (SignedRegistryEntry, error) {
+	return GetEntryResponse{
+		Data:      hex.EncodeToString(rsn.Data),
+		DataKey:   rsn.DataKey,
+		Revision:  rsn.Revision,
+		Signature: hex.EncodeToString(rsn.Signature[:]),
+		Type:      rsn.Type,
+	}.decode(dataKey)
+}
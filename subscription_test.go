@@ -0,0 +1,432 @@
+package skynet
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// roundTripperFunc is an http.RoundTripper that calls the function.
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper.
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// subscriptionServer is a stand-in for the registry subscription endpoint of a
+// portal.
+type subscriptionServer struct {
+	conns        map[*websocket.Conn]map[string]struct{}
+	numSubscribe int
+	// stalled contains the connections that no longer respond to pings,
+	// and refuse is set if new connections are refused.
+	stalled map[*websocket.Conn]struct{}
+	refuse  bool
+	mu      sync.Mutex
+}
+
+// ServeHTTP implements http.Handler.
+func (ss *subscriptionServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/skynet/registry/subscription" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	ss.mu.Lock()
+	refuse := ss.refuse
+	ss.mu.Unlock()
+	if refuse {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var upgrader websocket.Upgrader
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+	ss.mu.Lock()
+	ss.conns[conn] = make(map[string]struct{})
+	ss.mu.Unlock()
+	conn.SetPingHandler(func(data string) error {
+		ss.mu.Lock()
+		_, stalled := ss.stalled[conn]
+		ss.mu.Unlock()
+		if stalled {
+			return nil
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	for {
+		var sr registrySubscriptionRequest
+		if err := conn.ReadJSON(&sr); err != nil {
+			break
+		}
+		key := hex.EncodeToString(sr.PublicKey.Key) + "/" + sr.DataKey
+		ss.mu.Lock()
+		switch sr.Action {
+		case registrySubscriptionActionSubscribe:
+			ss.conns[conn][key] = struct{}{}
+			ss.numSubscribe++
+		case registrySubscriptionActionUnsubscribe:
+			delete(ss.conns[conn], key)
+		}
+		ss.mu.Unlock()
+	}
+
+	ss.mu.Lock()
+	delete(ss.conns, conn)
+	ss.mu.Unlock()
+	_ = conn.Close()
+}
+
+// publish sends the notification to the connections subscribed to its entry.
+func (ss *subscriptionServer) publish(notification registrySubscriptionNotification) {
+	key := hex.EncodeToString(notification.PublicKey.Key) + "/" + notification.DataKey
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for conn, subs := range ss.conns {
+		if _, ok := subs[key]; ok {
+			_ = conn.WriteJSON(notification)
+		}
+	}
+}
+
+// publishRaw sends the message to all connections.
+func (ss *subscriptionServer) publishRaw(message []byte) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for conn := range ss.conns {
+		_ = conn.WriteMessage(websocket.TextMessage, message)
+	}
+}
+
+// dropConnections closes all connections.
+func (ss *subscriptionServer) dropConnections() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for conn := range ss.conns {
+		_ = conn.Close()
+	}
+}
+
+// stallConnections makes the current connections stop responding to pings
+// without closing them, like half-open connections.
+func (ss *subscriptionServer) stallConnections() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.stalled == nil {
+		ss.stalled = make(map[*websocket.Conn]struct{})
+	}
+	for conn := range ss.conns {
+		ss.stalled[conn] = struct{}{}
+	}
+}
+
+// refuseConnections makes the server refuse new connections.
+func (ss *subscriptionServer) refuseConnections() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.refuse = true
+}
+
+// subscriptions returns the number of subscribe requests received so far.
+func (ss *subscriptionServer) subscriptions() int {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.numSubscribe
+}
+
+// waitFor waits until the condition is met or fails the test.
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// signedNotification returns the notification of the entry with the given
+// data key and revision, which contains the revision as data.
+func signedNotification(t *testing.T, privateKey ed25519.PrivateKey, dataKey string, revision uint64) registrySubscriptionNotification {
+	entry := RegistryEntry{DataKey: dataKey, Data: []byte{byte(revision)}, Revision: revision}
+	signedEntry, err := entry.Sign(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashedDataKey := HashDataKey(dataKey)
+	n := registrySubscriptionNotification{
+		PublicKey: registryPublicKey{Algorithm: "ed25519", Key: privateKey.Public().(ed25519.PublicKey)},
+		DataKey:   hex.EncodeToString(hashedDataKey[:]),
+		Revision:  revision,
+		Data:      entry.Data,
+		Type:      signedEntry.Type,
+	}
+	copy(n.Signature[:], signedEntry.Signature)
+	return n
+}
+
+// TestSubscribeEntry tests receiving registry entry updates over a websocket
+// connection that is lost and reestablished.
+func TestSubscribeEntry(t *testing.T) {
+	ss := &subscriptionServer{conns: make(map[*websocket.Conn]map[string]struct{})}
+	server := httptest.NewTLSServer(ss)
+	defer server.Close()
+	client := NewCustom(server.URL, Options{HTTPClient: server.Client()})
+	keyPair := GenKeyPairFromSeed("test seed")
+	const dataKey = "app"
+	opts := DefaultSubscribeEntryOptions
+	opts.ReconnectPolicy.InitialBackoff = 10 * time.Millisecond
+	opts.ReconnectPolicy.MaxBackoff = 50 * time.Millisecond
+
+	// notification returns the notification of the entry with the given
+	// revision.
+	notification := func(revision uint64) registrySubscriptionNotification {
+		return signedNotification(t, keyPair.PrivateKey, dataKey, revision)
+	}
+	// receive returns the next update and fails if it doesn't have the
+	// expected revision.
+	receive := func(updates <-chan SignedRegistryEntry, revision uint64) {
+		select {
+		case entry := <-updates:
+			if entry.Entry.Revision != revision || entry.Entry.Data[0] != byte(revision) {
+				t.Fatalf("expected revision %v, got %+v", revision, entry.Entry)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for revision %v", revision)
+		}
+	}
+
+	updates, err := client.SubscribeEntry(keyPair.PublicKey, dataKey, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.SubscribeEntry(keyPair.PublicKey, dataKey, opts)
+	if !errors.Contains(err, ErrAlreadySubscribed) {
+		t.Fatalf("expected error %v, got %v", ErrAlreadySubscribed, err)
+	}
+	waitFor(t, func() bool { return ss.subscriptions() == 1 })
+	ss.publish(notification(1))
+	receive(updates, 1)
+
+	// Entries that don't verify should be skipped.
+	tampered := notification(2)
+	tampered.Data = []byte("tampered")
+	ss.publish(tampered)
+	ss.publish(notification(3))
+	receive(updates, 3)
+
+	// The client should reconnect and resubscribe, skipping entries that
+	// were delivered already.
+	ss.dropConnections()
+	waitFor(t, func() bool { return ss.subscriptions() == 2 })
+	ss.publish(notification(3))
+	ss.publish(notification(4))
+	receive(updates, 4)
+
+	// Received revisions should be used for writes.
+	if revision, known := client.revisionCache().latest(keyPair.PublicKey, dataKey); !known || revision != 4 {
+		t.Fatalf("expected cached revision 4, got %v (known %v)", revision, known)
+	}
+
+	err = client.Unsubscribe(keyPair.PublicKey, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-updates; ok {
+		t.Fatal("expected the channel to be closed")
+	}
+	err = client.Unsubscribe(keyPair.PublicKey, dataKey)
+	if !errors.Contains(err, ErrNotSubscribed) {
+		t.Fatalf("expected error %v, got %v", ErrNotSubscribed, err)
+	}
+}
+
+// TestSubscribeEntryErrors tests that errors of subscriptions are reported
+// without reconnecting.
+func TestSubscribeEntryErrors(t *testing.T) {
+	ss := &subscriptionServer{conns: make(map[*websocket.Conn]map[string]struct{})}
+	server := httptest.NewTLSServer(ss)
+	defer server.Close()
+	client := NewCustom(server.URL, Options{HTTPClient: server.Client()})
+	keyPair := GenKeyPairFromSeed("test seed")
+	const dataKey = "app"
+	errs := make(chan error, 16)
+	opts := DefaultSubscribeEntryOptions
+	opts.OnError = func(err error) {
+		errs <- err
+	}
+	// receiveError returns the next reported error.
+	receiveError := func() error {
+		select {
+		case err := <-errs:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for error")
+			return nil
+		}
+	}
+
+	updates, err := client.SubscribeEntry(keyPair.PublicKey, dataKey, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Unsubscribe(keyPair.PublicKey, dataKey)
+	}()
+	waitFor(t, func() bool { return ss.subscriptions() == 1 })
+
+	// Malformed messages should be reported.
+	ss.publishRaw([]byte("not json"))
+	if err := receiveError(); err == nil {
+		t.Fatal("expected decoding error")
+	}
+
+	// Error notifications should be reported with the portal's message.
+	errNotification := signedNotification(t, keyPair.PrivateKey, dataKey, 1)
+	errNotification.Error = "portal failure"
+	ss.publish(errNotification)
+	err = receiveError()
+	if !errors.Contains(err, ErrSubscriptionNotification) || !strings.Contains(err.Error(), "portal failure") {
+		t.Fatalf("expected error %v, got %v", ErrSubscriptionNotification, err)
+	}
+
+	// Entries that don't verify should be reported.
+	tampered := signedNotification(t, keyPair.PrivateKey, dataKey, 1)
+	tampered.Data = []byte("tampered")
+	ss.publish(tampered)
+	if err := receiveError(); err == nil {
+		t.Fatal("expected verification error")
+	}
+
+	// The connection should still be used for updates.
+	ss.publish(signedNotification(t, keyPair.PrivateKey, dataKey, 2))
+	select {
+	case entry := <-updates:
+		if entry.Entry.Revision != 2 {
+			t.Fatalf("expected revision 2, got %v", entry.Entry.Revision)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for update")
+	}
+	if n := ss.subscriptions(); n != 1 {
+		t.Fatalf("expected no resubscription, got %v subscribe requests", n)
+	}
+}
+
+// TestSubscribeEntryRoundTripper tests that subscribing fails with HTTP clients
+// that don't use an *http.Transport.
+func TestSubscribeEntryRoundTripper(t *testing.T) {
+	ss := &subscriptionServer{conns: make(map[*websocket.Conn]map[string]struct{})}
+	server := httptest.NewTLSServer(ss)
+	defer server.Close()
+	httpClient := &http.Client{Transport: roundTripperFunc(server.Client().Transport.RoundTrip)}
+	client := NewCustom(server.URL, Options{HTTPClient: httpClient})
+	keyPair := GenKeyPairFromSeed("test seed")
+
+	_, err := client.SubscribeEntry(keyPair.PublicKey, "app", DefaultSubscribeEntryOptions)
+	if err == nil || !strings.Contains(err.Error(), "*http.Transport") {
+		t.Fatalf("expected transport error, got %v", err)
+	}
+}
+
+// TestSubscribeEntryKeepAlive tests that connections that stop responding are
+// detected with pings and reestablished.
+func TestSubscribeEntryKeepAlive(t *testing.T) {
+	ss := &subscriptionServer{conns: make(map[*websocket.Conn]map[string]struct{})}
+	server := httptest.NewTLSServer(ss)
+	defer server.Close()
+	client := NewCustom(server.URL, Options{HTTPClient: server.Client()})
+	keyPair := GenKeyPairFromSeed("test seed")
+	const dataKey = "app"
+	opts := DefaultSubscribeEntryOptions
+	opts.PingInterval = 50 * time.Millisecond
+	opts.ReconnectPolicy.InitialBackoff = 10 * time.Millisecond
+	opts.ReconnectPolicy.MaxBackoff = 50 * time.Millisecond
+
+	updates, err := client.SubscribeEntry(keyPair.PublicKey, dataKey, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Unsubscribe(keyPair.PublicKey, dataKey)
+	}()
+	waitFor(t, func() bool { return ss.subscriptions() == 1 })
+
+	// Idle connections that answer pings should be kept.
+	time.Sleep(5 * opts.PingInterval)
+	if n := ss.subscriptions(); n != 1 {
+		t.Fatalf("expected no resubscription, got %v subscribe requests", n)
+	}
+
+	// A connection that stops responding should be replaced.
+	ss.stallConnections()
+	waitFor(t, func() bool { return ss.subscriptions() == 2 })
+	ss.publish(signedNotification(t, keyPair.PrivateKey, dataKey, 1))
+	select {
+	case entry := <-updates:
+		if entry.Entry.Revision != 1 {
+			t.Fatalf("expected revision 1, got %v", entry.Entry.Revision)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for update")
+	}
+}
+
+// TestSubscribeEntryGiveUp tests that subscriptions are ended with an error
+// once the portal can't be reached anymore.
+func TestSubscribeEntryGiveUp(t *testing.T) {
+	ss := &subscriptionServer{conns: make(map[*websocket.Conn]map[string]struct{})}
+	server := httptest.NewTLSServer(ss)
+	defer server.Close()
+	client := NewCustom(server.URL, Options{HTTPClient: server.Client()})
+	keyPair := GenKeyPairFromSeed("test seed")
+	const dataKey = "app"
+	errs := make(chan error, 16)
+	opts := DefaultSubscribeEntryOptions
+	opts.ReconnectPolicy.MaxAttempts = 2
+	opts.ReconnectPolicy.InitialBackoff = 10 * time.Millisecond
+	opts.ReconnectPolicy.MaxBackoff = 50 * time.Millisecond
+	opts.OnError = func(err error) {
+		errs <- err
+	}
+
+	updates, err := client.SubscribeEntry(keyPair.PublicKey, dataKey, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return ss.subscriptions() == 1 })
+	ss.refuseConnections()
+	ss.dropConnections()
+
+	select {
+	case _, ok := <-updates:
+		if ok {
+			t.Fatal("expected the channel to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the subscription to end")
+	}
+	select {
+	case err := <-errs:
+		if !errors.Contains(err, ErrSubscriptionEnded) || !strings.Contains(err.Error(), "503") {
+			t.Fatalf("expected error %v with the dial error, got %v", ErrSubscriptionEnded, err)
+		}
+	default:
+		t.Fatal("expected an error before the channel was closed")
+	}
+	err = client.Unsubscribe(keyPair.PublicKey, dataKey)
+	if !errors.Contains(err, ErrNotSubscribed) {
+		t.Fatalf("expected error %v, got %v", ErrNotSubscribed, err)
+	}
+}
@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/go-skynet/v2"
+	"gitlab.com/NebulousLabs/errors"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// TestCustomHTTPClient tests that requests are made with the HTTP client set on
+// the client or passed to the API call.
+func TestCustomHTTPClient(t *testing.T) {
+	var clientRequests, callRequests int
+	respond := func(req *http.Request) *http.Response {
+		return &http.Response{
+			StatusCode: 200,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(strings.NewReader(`{"skykeys":[]}`)),
+			Request:    req,
+		}
+	}
+	httpClient := &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			clientRequests++
+			return respond(req), nil
+		}),
+	}
+	client2 := skynet.NewCustom("", skynet.Options{HTTPClient: httpClient})
+
+	// Test using the HTTP client set on the client.
+
+	_, err := client2.GetSkykeys(skynet.DefaultGetSkykeysOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientRequests != 1 {
+		t.Fatalf("expected %v requests, got %v", 1, clientRequests)
+	}
+
+	// Test that the HTTP client passed to the API call takes precedence.
+
+	opts := skynet.DefaultGetSkykeysOptions
+	opts.HTTPClient = &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			callRequests++
+			return respond(req), nil
+		}),
+	}
+	_, err = client2.GetSkykeys(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientRequests != 1 || callRequests != 1 {
+		t.Fatalf("expected %v and %v requests, got %v and %v", 1, 1, clientRequests, callRequests)
+	}
+}
+
+// TestRetries tests that requests are retried according to the retry policy.
+func TestRetries(t *testing.T) {
+	var statusCodes []int
+	var requests int
+	retryAfter := "0"
+	httpClient := &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			statusCode := statusCodes[requests]
+			requests++
+			header := make(http.Header)
+			if statusCode == http.StatusTooManyRequests {
+				header.Set("Retry-After", retryAfter)
+			}
+			return &http.Response{
+				StatusCode: statusCode,
+				Header:     header,
+				Body:       ioutil.NopCloser(strings.NewReader(`{"skykeys":[]}`)),
+				Request:    req,
+			}, nil
+		}),
+	}
+	opts := skynet.Options{
+		HTTPClient: httpClient,
+		RetryPolicy: skynet.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+		},
+	}
+	client2 := skynet.NewCustom("", opts)
+
+	// Test that transient errors are retried.
+
+	statusCodes = []int{503, 429, 200}
+	_, err := client2.GetSkykeys(skynet.DefaultGetSkykeysOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Fatalf("expected %v requests, got %v", 3, requests)
+	}
+
+	// Test that the error reports every attempt once all attempts failed.
+
+	requests = 0
+	statusCodes = []int{502, 503, 503}
+	_, err = client2.GetSkykeys(skynet.DefaultGetSkykeysOptions)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if requests != 3 {
+		t.Fatalf("expected %v requests, got %v", 3, requests)
+	}
+	for _, s := range []string{"attempt 1 failed", "attempt 2 failed", "attempt 3 failed"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("expected error to contain %q, got %v", s, err)
+		}
+	}
+
+	// Test that waits requested by the portal are limited by MaxBackoff.
+
+	requests = 0
+	retryAfter = "86400"
+	statusCodes = []int{429, 200}
+	start := time.Now()
+	_, err = client2.GetSkykeys(skynet.DefaultGetSkykeysOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected Retry-After to be limited, waited %v", elapsed)
+	}
+	if requests != 2 {
+		t.Fatalf("expected %v requests, got %v", 2, requests)
+	}
+
+	// Test that other errors are not retried.
+
+	requests = 0
+	statusCodes = []int{404}
+	_, err = client2.GetSkykeys(skynet.DefaultGetSkykeysOptions)
+	if !errors.Contains(err, skynet.ErrResponseError) {
+		t.Fatalf("expected error %v, got %v", skynet.ErrResponseError, err)
+	}
+	if requests != 1 {
+		t.Fatalf("expected %v requests, got %v", 1, requests)
+	}
+}
+
+// TestResponseError tests that error responses are returned as ResponseErrors.
+func TestResponseError(t *testing.T) {
+	defer gock.Off()
+
+	const skykey = "skykey:secret"
+
+	opts := skynet.DefaultAddSkykeyOptions
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.EndpointPath).
+		MatchParam("skykey", skykey).
+		Reply(401).
+		SetHeader("Foo", "bar").
+		JSON(map[string]string{"message": "invalid API key"})
+
+	err := client.AddSkykey(skykey, opts)
+	if !errors.Contains(err, skynet.ErrResponseError) {
+		t.Fatalf("expected error %v, got %v", skynet.ErrResponseError, err)
+	}
+	if !skynet.IsUnauthorized(err) || skynet.IsNotFound(err) || skynet.IsRateLimited(err) {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+	respErr, ok := skynet.AsResponseError(err)
+	if !ok {
+		t.Fatalf("expected ResponseError, got %v", err)
+	}
+	if respErr.StatusCode != 401 || respErr.Method != "POST" || respErr.Message != "invalid API key" {
+		t.Fatalf("unexpected ResponseError %v", respErr)
+	}
+	if respErr.Header.Get("Foo") != "bar" {
+		t.Fatalf("expected header %v, got %v", "bar", respErr.Header.Get("Foo"))
+	}
+	if strings.Contains(respErr.URL, "secret") {
+		t.Fatalf("expected skykey to be redacted from URL %v", respErr.URL)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
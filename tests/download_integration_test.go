@@ -2,12 +2,19 @@ package tests
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/SkynetLabs/go-skynet/v2"
+	"gitlab.com/NebulousLabs/errors"
 	"gopkg.in/h2non/gock.v1"
 )
 
@@ -60,6 +67,25 @@ func TestDownloadFile(t *testing.T) {
 	}
 }
 
+// TestDownloadFileWithContext tests that downloading a file with a cancelled
+// context fails.
+func TestDownloadFileWithContext(t *testing.T) {
+	defer gock.Off()
+
+	file, err := ioutil.TempFile("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstFile := file.Name()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = client.DownloadFileWithContext(ctx, dstFile, sialink, skynet.DefaultDownloadOptions)
+	if !errors.Contains(err, context.Canceled) {
+		t.Fatalf("expected error %v, got %v", context.Canceled, err)
+	}
+}
+
 func TestDownloadFileSkykey(t *testing.T) {
 	defer gock.Off() // Flush pending mocks after test execution
 
@@ -140,3 +166,359 @@ func TestDownloadFileSkykey(t *testing.T) {
 		t.Fatal("test finished with pending mocks")
 	}
 }
+
+// TestMetadata tests getting the metadata of a skyfile.
+func TestMetadata(t *testing.T) {
+	defer gock.Off()
+
+	const metadataJSON = `{"filename":"testdata","length":9,"subfiles":{"dir1/file3.txt":{"filename":"dir1/file3.txt","contenttype":"text/plain","len":4},"file1.txt":{"filename":"file1.txt","contenttype":"text/plain","offset":4,"len":5}},"defaultpath":"/file1.txt","tryfiles":["index.html"],"errorpages":{"404":"/404.html"}}`
+
+	opts := skynet.DefaultMetadataOptions
+	urlpath := strings.TrimRight(opts.EndpointPath, "/") + "/" + skylink
+	gock.New(skynet.DefaultPortalURL()).
+		Head(urlpath).
+		Reply(200).
+		SetHeader("Content-Type", "application/zip").
+		SetHeader("Skynet-Skylink", skylink).
+		SetHeader("Skynet-File-Metadata", metadataJSON)
+
+	// Pass the full sialink to verify that the prefix is trimmed.
+	resp, err := client.Metadata(sialink, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Skylink.URI() != sialink {
+		t.Fatalf("expected skylink %v, got %v", sialink, resp.Skylink)
+	}
+	if resp.ContentType != "application/zip" {
+		t.Fatalf("expected content type %v, got %v", "application/zip", resp.ContentType)
+	}
+	metadata := resp.Metadata
+	if metadata.Filename != "testdata" || metadata.Length != 9 {
+		t.Fatalf("unexpected filename %v and length %v", metadata.Filename, metadata.Length)
+	}
+	if metadata.DefaultPath != "/file1.txt" {
+		t.Fatalf("expected default path %v, got %v", "/file1.txt", metadata.DefaultPath)
+	}
+	if len(metadata.TryFiles) != 1 || metadata.TryFiles[0] != "index.html" {
+		t.Fatalf("unexpected tryfiles %v", metadata.TryFiles)
+	}
+	if metadata.ErrorPages[404] != "/404.html" {
+		t.Fatalf("unexpected error pages %v", metadata.ErrorPages)
+	}
+	subfile, ok := metadata.Subfiles["file1.txt"]
+	if !ok {
+		t.Fatal("expected subfile file1.txt")
+	}
+	if subfile.ContentType != "text/plain" || subfile.Offset != 4 || subfile.Len != 5 {
+		t.Fatalf("unexpected subfile metadata %+v", subfile)
+	}
+
+	// Test that a response without metadata fails.
+
+	gock.New(skynet.DefaultPortalURL()).
+		Head(urlpath).
+		Reply(200).
+		SetHeader("Skynet-Skylink", skylink)
+
+	_, err = client.Metadata(skylink, opts)
+	if err == nil || !strings.Contains(err.Error(), "Skynet-File-Metadata") {
+		t.Fatalf("expected missing metadata error, got %v", err)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
+
+// TestDownloadRange tests downloading a range of a file.
+func TestDownloadRange(t *testing.T) {
+	defer gock.Off()
+
+	opts := skynet.DefaultDownloadOptions
+	urlpath := strings.TrimRight(opts.EndpointPath, "/") + "/" + skylink
+	gock.New(skynet.DefaultPortalURL()).
+		Get(urlpath).
+		MatchHeader("Range", "^bytes=1-3$").
+		Reply(206).
+		SetHeader("Content-Range", "bytes 1-3/5").
+		BodyString("est")
+
+	body, contentRange, err := client.DownloadRange(sialink, 1, 3, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "est" {
+		t.Fatalf("expected data %q, got %q", "est", data)
+	}
+	expected := skynet.ContentRange{Start: 1, End: 3, Size: 5}
+	if contentRange != expected {
+		t.Fatalf("expected range %+v, got %+v", expected, contentRange)
+	}
+
+	// Test an open-ended range.
+
+	gock.New(skynet.DefaultPortalURL()).
+		Get(urlpath).
+		MatchHeader("Range", "^bytes=2-$").
+		Reply(206).
+		SetHeader("Content-Range", "bytes 2-4/5").
+		BodyString("st\n")
+
+	body, _, err = client.DownloadRange(sialink, 2, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := body.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test that a portal ignoring the range is detected.
+
+	gock.New(skynet.DefaultPortalURL()).
+		Get(urlpath).
+		Reply(200).
+		BodyString("test\n")
+
+	_, _, err = client.DownloadRange(sialink, 1, 3, opts)
+	if !errors.Contains(err, skynet.ErrRangeIgnored) {
+		t.Fatalf("expected error %v, got %v", skynet.ErrRangeIgnored, err)
+	}
+
+	// Test that an unsatisfiable range fails with the response error.
+
+	gock.New(skynet.DefaultPortalURL()).
+		Get(urlpath).
+		Reply(416).
+		JSON(map[string]string{"message": "requested range not satisfiable"})
+
+	_, _, err = client.DownloadRange(sialink, 10, 3, opts)
+	respErr, ok := skynet.AsResponseError(err)
+	if !ok || respErr.StatusCode != 416 {
+		t.Fatalf("expected 416 response error, got %v", err)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
+
+// TestDownloadFileResume tests resuming an interrupted download.
+func TestDownloadFileResume(t *testing.T) {
+	content := "test\n"
+	const etag = `"etag"`
+
+	// Serve the content, supporting ranges, and fail after failAfter bytes
+	// of the body if it is not negative.
+	failAfter := -1
+	var ranges []string
+	httpClient := &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			header := make(http.Header)
+			header.Set("ETag", etag)
+			header.Set("Skynet-Skylink", skylink)
+			status, data := http.StatusOK, content
+			rangeHeader := req.Header.Get("Range")
+			ranges = append(ranges, rangeHeader)
+			if rangeHeader != "" && req.Header.Get("If-Range") == etag {
+				var start int
+				if _, err := fmt.Sscanf(rangeHeader, "bytes=%d-", &start); err != nil {
+					return nil, err
+				}
+				if start < len(content) {
+					status, data = http.StatusPartialContent, content[start:]
+					header.Set("Content-Range", fmt.Sprintf("bytes %v-%v/%v", start, len(content)-1, len(content)))
+				} else {
+					status, data = http.StatusRequestedRangeNotSatisfiable, ""
+					header.Set("Content-Range", fmt.Sprintf("bytes */%v", len(content)))
+				}
+			}
+			var body io.Reader = strings.NewReader(data)
+			if failAfter >= 0 {
+				pr, pw := io.Pipe()
+				go func(data string) {
+					_, _ = pw.Write([]byte(data))
+					_ = pw.CloseWithError(errors.New("connection reset"))
+				}(data[:failAfter])
+				body = pr
+			}
+			return &http.Response{
+				StatusCode:    status,
+				Header:        header,
+				Body:          ioutil.NopCloser(body),
+				ContentLength: int64(len(data)),
+				Request:       req,
+			}, nil
+		}),
+	}
+
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	dstFile := filepath.Join(dir, "file")
+	partialFile := dstFile + skynet.PartialDownloadSuffix
+
+	opts := skynet.DefaultDownloadOptions
+	opts.HTTPClient = httpClient
+	opts.Resume = true
+
+	// Interrupt the download after 2 bytes.
+
+	failAfter = 2
+	err = client.DownloadFile(dstFile, sialink, opts)
+	if err == nil {
+		t.Fatal("expected interrupted download to fail")
+	}
+	if _, err := os.Stat(dstFile); !os.IsNotExist(err) {
+		t.Fatalf("expected no file at destination, got %v", err)
+	}
+	partial, err := ioutil.ReadFile(partialFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(partial) != content[:2] {
+		t.Fatalf("expected partial file %q, got %q", content[:2], partial)
+	}
+
+	// Resume the download.
+
+	failAfter = -1
+	var reports []skynet.DownloadProgress
+	opts.Progress = func(progress skynet.DownloadProgress) {
+		reports = append(reports, progress)
+	}
+	err = client.DownloadFile(dstFile, sialink, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ranges[len(ranges)-1] != "bytes=2-" {
+		t.Fatalf("expected resumed range %q, got %q", "bytes=2-", ranges[len(ranges)-1])
+	}
+	data, err := ioutil.ReadFile(dstFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Fatalf("expected file %q, got %q", content, data)
+	}
+	last := reports[len(reports)-1]
+	if last.BytesReceived != int64(len(content)) || last.ContentLength != int64(len(content)) {
+		t.Fatalf("unexpected last report %+v", last)
+	}
+	// No partial download files should be left.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected only the downloaded file, got %v files", len(files))
+	}
+
+	// A partial file of a different skylink should not be resumed.
+
+	opts.Progress = nil
+	failAfter = 2
+	err = client.DownloadFile(dstFile, "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", opts)
+	if err == nil {
+		t.Fatal("expected interrupted download to fail")
+	}
+	failAfter = -1
+	err = client.DownloadFile(dstFile, sialink, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ranges[len(ranges)-1] != "" {
+		t.Fatalf("expected full download, got range %q", ranges[len(ranges)-1])
+	}
+	data, err = ioutil.ReadFile(dstFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Fatalf("expected file %q, got %q", content, data)
+	}
+
+	// A partial file that is larger than the file should be discarded.
+
+	content = "longer test\n"
+	failAfter = 8
+	err = client.DownloadFile(dstFile, sialink, opts)
+	if err == nil {
+		t.Fatal("expected interrupted download to fail")
+	}
+	content = "test\n"
+	failAfter = -1
+	err = client.DownloadFile(dstFile, sialink, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ranges[len(ranges)-2] != "bytes=8-" || ranges[len(ranges)-1] != "" {
+		t.Fatalf("expected full download after range %q, got ranges %q", "bytes=8-", ranges[len(ranges)-2:])
+	}
+	data, err = ioutil.ReadFile(dstFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Fatalf("expected file %q, got %q", content, data)
+	}
+}
+
+// TestDownloadFromURL tests downloading from skylinks pasted as URLs.
+func TestDownloadFromURL(t *testing.T) {
+	defer gock.Off()
+
+	sl, err := skynet.ParseSkylink(skylink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := skynet.DefaultDownloadOptions
+	urlpath := strings.TrimRight(opts.EndpointPath, "/") + "/" + skylink + "/dir/file.txt"
+	for i := 0; i < 2; i++ {
+		gock.New(skynet.DefaultPortalURL()).
+			Get(urlpath).
+			MatchParam("format", "zip").
+			Reply(200).
+			BodyString("test\n")
+	}
+
+	urls := []string{
+		"https://siasky.net/" + skylink + "/dir/file.txt?format=zip",
+		"https://" + sl.Base32EncodedString() + ".siasky.net/dir/file.txt?format=zip",
+	}
+	for _, u := range urls {
+		body, err := client.Download(u, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := body.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Test that an invalid skylink is rejected before making a request.
+	_, err = client.Download("https://siasky.net/invalid", opts)
+	if !errors.Contains(err, skynet.ErrMalformedSkylink) {
+		t.Fatalf("expected error %v, got %v", skynet.ErrMalformedSkylink, err)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
@@ -0,0 +1,513 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/SkynetLabs/go-skynet/v2"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// fakePortal is an in-memory stand-in for the upload, download and
+	// registry endpoints of a portal.
+	fakePortal struct {
+		files        map[string][]byte
+		contentTypes map[string]string
+		registry     map[string]fakeRegistryEntry
+		mu           sync.Mutex
+	}
+
+	// fakeRegistryEntry is a registry entry stored by a fakePortal, in the
+	// form of the registry POST request.
+	fakeRegistryEntry struct {
+		PublicKey struct {
+			Algorithm string `json:"algorithm"`
+			Key       []byte `json:"key"`
+		} `json:"publickey"`
+		DataKey   string   `json:"datakey"`
+		Revision  uint64   `json:"revision"`
+		Signature [64]byte `json:"signature"`
+		Data      []byte   `json:"data"`
+		Type      uint8    `json:"type"`
+	}
+)
+
+// newFakePortal starts a fake portal and returns a client for it. The returned
+// server has to be closed by the caller.
+func newFakePortal() (*fakePortal, *httptest.Server, skynet.SkynetClient) {
+	fp := &fakePortal{
+		files:        make(map[string][]byte),
+		contentTypes: make(map[string]string),
+		registry:     make(map[string]fakeRegistryEntry),
+	}
+	server := httptest.NewTLSServer(fp)
+	return fp, server, skynet.NewCustom(server.URL, skynet.Options{HTTPClient: server.Client()})
+}
+
+// ServeHTTP implements http.Handler.
+func (fp *fakePortal) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	switch {
+	case req.URL.Path == "/skynet/skyfile" && req.Method == "POST":
+		fp.handleUpload(w, req)
+	case req.URL.Path == "/skynet/registry" && req.Method == "GET":
+		fp.handleGetEntry(w, req)
+	case req.URL.Path == "/skynet/registry" && req.Method == "POST":
+		fp.handleSetEntry(w, req)
+	case req.Method == "GET":
+		data, ok := fp.files[strings.TrimPrefix(req.URL.Path, "/")]
+		if !ok {
+			writeFakePortalError(w, http.StatusNotFound, "skylink not found")
+			return
+		}
+		_, _ = w.Write(data)
+	default:
+		writeFakePortalError(w, http.StatusNotFound, "unknown endpoint")
+	}
+}
+
+// handleUpload stores an uploaded file under the skylink it would get.
+func (fp *fakePortal) handleUpload(w http.ResponseWriter, req *http.Request) {
+	file, header, err := req.FormFile("file")
+	if err != nil {
+		writeFakePortalError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		writeFakePortalError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	uploadData := skynet.UploadData{header.Filename: strings.NewReader(string(data))}
+	skylink, err := skynet.ComputeSkylink(uploadData, skynet.DefaultUploadOptions)
+	if err != nil {
+		writeFakePortalError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	fp.files[skylink.String()] = data
+	fp.contentTypes[skylink.String()] = header.Header.Get("Content-Type")
+	_ = json.NewEncoder(w).Encode(skynet.UploadResponse{Skylink: skylink.String()})
+}
+
+// handleGetEntry returns a registry entry.
+func (fp *fakePortal) handleGetEntry(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	entry, ok := fp.registry[query.Get("publickey")+"/"+query.Get("datakey")]
+	if !ok {
+		writeFakePortalError(w, http.StatusNotFound, "unable to read from the registry: registry entry not found within given time")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(skynet.GetEntryResponse{
+		Data:      hex.EncodeToString(entry.Data),
+		DataKey:   entry.DataKey,
+		Revision:  entry.Revision,
+		Signature: hex.EncodeToString(entry.Signature[:]),
+		Type:      skynet.RegistryEntryType(entry.Type),
+	})
+}
+
+// handleSetEntry stores a registry entry if it has a higher revision than the
+// stored one.
+func (fp *fakePortal) handleSetEntry(w http.ResponseWriter, req *http.Request) {
+	var entry fakeRegistryEntry
+	err := json.NewDecoder(req.Body).Decode(&entry)
+	if err != nil {
+		writeFakePortalError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	key := entry.PublicKey.Algorithm + ":" + hex.EncodeToString(entry.PublicKey.Key) + "/" + entry.DataKey
+	if stored, ok := fp.registry[key]; ok {
+		if entry.Revision == stored.Revision {
+			writeFakePortalError(w, http.StatusInternalServerError, "Unable to update the registry: provided revision number is already registered")
+			return
+		}
+		if entry.Revision < stored.Revision {
+			writeFakePortalError(w, http.StatusInternalServerError, "Unable to update the registry: provided revision number is invalid")
+			return
+		}
+	}
+	fp.registry[key] = entry
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeFakePortalError writes an error response like skyd does.
+func writeFakePortalError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// TestSkyDBJSON tests setting and getting JSON in SkyDB.
+func TestSkyDBJSON(t *testing.T) {
+	fp, server, client := newFakePortal()
+	defer server.Close()
+	keyPair := skynet.GenKeyPairFromSeed("test seed")
+	const dataKey = "app"
+
+	type appState struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	// Getting JSON that was never set should fail.
+	var state appState
+	_, err := client.GetJSON(keyPair.PublicKey, dataKey, &state, skynet.DefaultGetJSONOptions)
+	if !errors.Contains(err, skynet.ErrRegistryEntryNotFound) {
+		t.Fatalf("expected error %v, got %v", skynet.ErrRegistryEntryNotFound, err)
+	}
+
+	// Set the JSON twice, the revision should be incremented.
+	for i := 0; i < 2; i++ {
+		expected := appState{Name: "test", Count: i}
+		skylink, err := client.SetJSON(keyPair.PrivateKey, dataKey, expected, skynet.DefaultSetJSONOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := client.GetEntry(keyPair.PublicKey, dataKey, skynet.DefaultGetEntryOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Entry.Revision != uint64(i) {
+			t.Fatalf("expected revision %v, got %v", i, entry.Entry.Revision)
+		}
+
+		var state appState
+		skylink2, err := client.GetJSON(keyPair.PublicKey, dataKey, &state, skynet.DefaultGetJSONOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skylink2 != skylink {
+			t.Fatalf("expected skylink %v, got %v", skylink, skylink2)
+		}
+		if state != expected {
+			t.Fatalf("expected JSON %+v, got %+v", expected, state)
+		}
+	}
+
+	// The JSON should be stored in the envelope used by the other SDKs.
+	skylink, err := client.GetJSON(keyPair.PublicKey, dataKey, &state, skynet.DefaultGetJSONOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := string(fp.files[skylink.String()]); stored != `{"_data":{"name":"test","count":1},"_v":2}` {
+		t.Fatalf("unexpected stored JSON %v", stored)
+	}
+	if contentType := fp.contentTypes[skylink.String()]; contentType != "application/json" {
+		t.Fatalf("expected content type %v, got %v", "application/json", contentType)
+	}
+}
+
+// TestSkyDBJSONLegacy tests getting JSON that isn't stored in the envelope,
+// pointed to by a base64 encoded skylink.
+func TestSkyDBJSONLegacy(t *testing.T) {
+	fp, server, client := newFakePortal()
+	defer server.Close()
+	keyPair := skynet.GenKeyPairFromSeed("test seed")
+	const dataKey = "app"
+
+	skylink, err := client.Upload(skynet.UploadData{"file": strings.NewReader(`{"count":3}`)}, skynet.DefaultUploadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := skynet.RegistryEntry{DataKey: dataKey, Data: []byte(skylink.String()), Revision: 0}
+	err = client.SetEntry(keyPair.PrivateKey, entry, skynet.DefaultSetEntryOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var state struct {
+		Count int `json:"count"`
+	}
+	skylink2, err := client.GetJSON(keyPair.PublicKey, dataKey, &state, skynet.DefaultGetJSONOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skylink2 != skylink || state.Count != 3 {
+		t.Fatalf("unexpected skylink %v or JSON %+v", skylink2, state)
+	}
+	if len(fp.files) != 1 {
+		t.Fatalf("expected 1 file, got %v", len(fp.files))
+	}
+}
+
+// TestSkyDBRawBytes tests setting, getting and deleting raw bytes in SkyDB.
+func TestSkyDBRawBytes(t *testing.T) {
+	_, server, client := newFakePortal()
+	defer server.Close()
+	keyPair := skynet.GenKeyPairFromSeed("test seed")
+	const dataKey = "app"
+
+	// The revision should be incremented from the latest revision.
+	for i := 0; i < 2; i++ {
+		data := []byte{byte(i)}
+		revision, err := client.SetRawBytes(keyPair.PrivateKey, dataKey, data, skynet.DefaultSetRawBytesOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revision != uint64(i) {
+			t.Fatalf("expected revision %v, got %v", i, revision)
+		}
+		data2, revision2, err := client.GetRawBytes(keyPair.PublicKey, dataKey, skynet.DefaultGetRawBytesOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data2, data) || revision2 != revision {
+			t.Fatalf("expected data %v at revision %v, got %v at revision %v", data, revision, data2, revision2)
+		}
+	}
+
+	// Set an explicit revision.
+	opts := skynet.DefaultSetRawBytesOptions
+	revision := uint64(10)
+	opts.Revision = &revision
+	written, err := client.SetRawBytes(keyPair.PrivateKey, dataKey, []byte("explicit"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != revision {
+		t.Fatalf("expected revision %v, got %v", revision, written)
+	}
+	_, err = client.SetRawBytes(keyPair.PrivateKey, dataKey, []byte("explicit"), opts)
+	if !errors.Contains(err, skynet.ErrSameRevision) {
+		t.Fatalf("expected error %v, got %v", skynet.ErrSameRevision, err)
+	}
+
+	// Data that doesn't fit in the entry should be rejected.
+	_, err = client.SetRawBytes(keyPair.PrivateKey, dataKey, make([]byte, skynet.RegistryDataSize+1), skynet.DefaultSetRawBytesOptions)
+	if !errors.Contains(err, skynet.ErrRegistryDataTooLarge) {
+		t.Fatalf("expected error %v, got %v", skynet.ErrRegistryDataTooLarge, err)
+	}
+
+	// Deleted entries should not be found.
+	revision, err = client.DeleteEntry(keyPair.PrivateKey, dataKey, skynet.DefaultDeleteEntryOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision != 11 {
+		t.Fatalf("expected revision 11, got %v", revision)
+	}
+	_, _, err = client.GetRawBytes(keyPair.PublicKey, dataKey, skynet.DefaultGetRawBytesOptions)
+	if !errors.Contains(err, skynet.ErrRegistryEntryNotFound) {
+		t.Fatalf("expected error %v, got %v", skynet.ErrRegistryEntryNotFound, err)
+	}
+	var v interface{}
+	_, err = client.GetJSON(keyPair.PublicKey, dataKey, &v, skynet.DefaultGetJSONOptions)
+	if !errors.Contains(err, skynet.ErrRegistryEntryNotFound) {
+		t.Fatalf("expected error %v, got %v", skynet.ErrRegistryEntryNotFound, err)
+	}
+}
+
+// TestSkyDBDataLink tests pointing SkyDB entries to skylinks.
+func TestSkyDBDataLink(t *testing.T) {
+	_, server, client := newFakePortal()
+	defer server.Close()
+	keyPair := skynet.GenKeyPairFromSeed("test seed")
+	const dataKey = "app"
+
+	skylink, err := client.Upload(skynet.UploadData{"file": strings.NewReader(`{"count":3}`)}, skynet.DefaultUploadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revision, err := client.SetDataLink(keyPair.PrivateKey, dataKey, "sia://"+skylink.String(), skynet.DefaultSetDataLinkOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision != 0 {
+		t.Fatalf("expected revision 0, got %v", revision)
+	}
+
+	var state struct {
+		Count int `json:"count"`
+	}
+	skylink2, err := client.GetJSON(keyPair.PublicKey, dataKey, &state, skynet.DefaultGetJSONOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skylink2 != skylink || state.Count != 3 {
+		t.Fatalf("unexpected skylink %v or JSON %+v", skylink2, state)
+	}
+
+	_, err = client.SetDataLink(keyPair.PrivateKey, dataKey, "not a skylink", skynet.DefaultSetDataLinkOptions)
+	if err == nil {
+		t.Fatal("expected setting an invalid data link to fail")
+	}
+}
+
+// TestSkyDBConcurrentWrites tests that concurrent writes to the same entry by
+// clients of the same process don't conflict.
+func TestSkyDBConcurrentWrites(t *testing.T) {
+	_, server, client := newFakePortal()
+	defer server.Close()
+	otherClient := skynet.NewCustom(server.URL, client.Options)
+	keyPair := skynet.GenKeyPairFromSeed("test seed")
+	const dataKey = "app"
+	const numWrites = 10
+
+	var wg sync.WaitGroup
+	revisions := make([]uint64, numWrites)
+	errs := make([]error, numWrites)
+	for i := 0; i < numWrites; i++ {
+		c := client
+		if i%2 == 1 {
+			c = otherClient
+		}
+		wg.Add(1)
+		go func(i int, c skynet.SkynetClient) {
+			defer wg.Done()
+			revisions[i], errs[i] = c.SetRawBytes(keyPair.PrivateKey, dataKey, []byte{byte(i)}, skynet.DefaultSetRawBytesOptions)
+		}(i, c)
+	}
+	wg.Wait()
+
+	written := make(map[uint64]struct{})
+	for i := range errs {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		written[revisions[i]] = struct{}{}
+	}
+	for revision := uint64(0); revision < numWrites; revision++ {
+		if _, ok := written[revision]; !ok {
+			t.Fatalf("revision %v wasn't written", revision)
+		}
+	}
+}
+
+// TestSkyDBMerge tests resolving conflicting writes by several clients with a
+// merge function.
+func TestSkyDBMerge(t *testing.T) {
+	_, server, client := newFakePortal()
+	defer server.Close()
+	otherClient := skynet.NewCustom(server.URL, client.Options)
+	keyPair := skynet.GenKeyPairFromSeed("test seed")
+	const dataKey = "counter"
+	const numIncrements = 10
+
+	_, err := client.SetRawBytes(keyPair.PrivateKey, dataKey, []byte{0}, skynet.DefaultSetRawBytesOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Increment the counter concurrently with both clients, basing every
+	// write on the revision that was read.
+	increment := func(current []byte) ([]byte, error) {
+		return []byte{current[0] + 1}, nil
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, 2*numIncrements)
+	for i := range errs {
+		c := client
+		if i%2 == 1 {
+			c = otherClient
+		}
+		wg.Add(1)
+		go func(i int, c skynet.SkynetClient) {
+			defer wg.Done()
+			data, revision, err := c.GetRawBytes(keyPair.PublicKey, dataKey, skynet.DefaultGetRawBytesOptions)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			opts := skynet.DefaultSetRawBytesOptions
+			revision++
+			opts.Revision = &revision
+			opts.Merge = increment
+			opts.MaxConflictRetries = 10 * len(errs)
+			data, _ = increment(data)
+			_, errs[i] = c.SetRawBytes(keyPair.PrivateKey, dataKey, data, opts)
+		}(i, c)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, _, err := client.GetRawBytes(keyPair.PublicKey, dataKey, skynet.DefaultGetRawBytesOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(data[0]) != len(errs) {
+		t.Fatalf("expected counter %v, got %v", len(errs), data[0])
+	}
+
+	// Without a merge function the conflict should be returned.
+	opts := skynet.DefaultSetRawBytesOptions
+	revision := uint64(1)
+	opts.Revision = &revision
+	_, err = client.SetRawBytes(keyPair.PrivateKey, dataKey, []byte{0}, opts)
+	if !errors.Contains(err, skynet.ErrRevisionConflict) {
+		t.Fatalf("expected error %v, got %v", skynet.ErrRevisionConflict, err)
+	}
+}
+
+// TestSkyDBJSONMerge tests resolving a conflicting JSON write with a merge
+// function.
+func TestSkyDBJSONMerge(t *testing.T) {
+	_, server, client := newFakePortal()
+	defer server.Close()
+	otherClient := skynet.NewCustom(server.URL, client.Options)
+	keyPair := skynet.GenKeyPairFromSeed("test seed")
+	const dataKey = "list"
+
+	_, err := client.SetJSON(keyPair.PrivateKey, dataKey, []string{"a"}, skynet.DefaultSetJSONOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = otherClient.SetJSON(keyPair.PrivateKey, dataKey, []string{"a", "b"}, skynet.DefaultSetJSONOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Writing the revision the other client wrote should conflict.
+	opts := skynet.DefaultSetJSONOptions
+	revision := uint64(1)
+	opts.Revision = &revision
+	_, err = client.SetJSON(keyPair.PrivateKey, dataKey, []string{"a", "c"}, opts)
+	if !errors.Contains(err, skynet.ErrRevisionConflict) {
+		t.Fatalf("expected error %v, got %v", skynet.ErrRevisionConflict, err)
+	}
+
+	// With a merge function the value should be merged with the latest JSON.
+	opts.Merge = func(current json.RawMessage) (interface{}, error) {
+		var list []string
+		if err := json.Unmarshal(current, &list); err != nil {
+			return nil, err
+		}
+		return append(list, "c"), nil
+	}
+	skylink, err := client.SetJSON(keyPair.PrivateKey, dataKey, []string{"a", "c"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []string
+	getSkylink, err := otherClient.GetJSON(keyPair.PublicKey, dataKey, &list, skynet.DefaultGetJSONOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getSkylink != skylink {
+		t.Fatalf("expected skylink %v, got %v", skylink, getSkylink)
+	}
+	if !reflect.DeepEqual(list, []string{"a", "b", "c"}) {
+		t.Fatalf("expected [a b c], got %v", list)
+	}
+	_, revision, err = otherClient.GetRawBytes(keyPair.PublicKey, dataKey, skynet.DefaultGetRawBytesOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision != 2 {
+		t.Fatalf("expected revision 2, got %v", revision)
+	}
+}
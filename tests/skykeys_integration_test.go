@@ -164,3 +164,40 @@ func TestGetSkykeys(t *testing.T) {
 		t.Fatal("test finished with pending mocks")
 	}
 }
+
+// TestDeleteSkykey tests deleting a skykey by name and by ID.
+func TestDeleteSkykey(t *testing.T) {
+	defer gock.Off()
+
+	const name = "testcreateskykey"
+	const id = "pJAPPfWkWXpss3BvMDCJCw=="
+
+	opts := skynet.DefaultDeleteSkykeyOptions
+
+	// Delete by name
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.EndpointPath).
+		MatchParam("name", name).
+		Reply(204)
+
+	err := client.DeleteSkykeyByName(name, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete by ID
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.EndpointPath).
+		MatchParam("id", id).
+		Reply(204)
+
+	err = client.DeleteSkykeyByID(id, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
@@ -22,3 +22,13 @@ var (
 	// interceptedRequest contains the raw data of intercepted requests.
 	interceptedRequest string
 )
+
+type (
+	// roundTripperFunc is an http.RoundTripper implemented by a function.
+	roundTripperFunc func(*http.Request) (*http.Response, error)
+)
+
+// RoundTrip implements http.RoundTripper.
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -1,11 +1,18 @@
 package tests
 
 import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/SkynetLabs/go-skynet/v2"
+	"gitlab.com/NebulousLabs/errors"
 	"gopkg.in/h2non/gock.v1"
 )
 
@@ -49,7 +56,7 @@ func TestUploadFile(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if sialink2 != sialink {
+	if sialink2.URI() != sialink {
 		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
 	}
 
@@ -64,6 +71,120 @@ func TestUploadFile(t *testing.T) {
 	}
 }
 
+// TestUploadFileWithContext tests uploading a single file with a context.
+func TestUploadFileWithContext(t *testing.T) {
+	defer gock.Off()
+
+	opts := skynet.DefaultUploadOptions
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.EndpointPath).
+		Reply(200).
+		JSON(map[string]string{"skylink": skylink})
+
+	// Test uploading with a live context.
+
+	sialink2, err := client.UploadFileWithContext(context.Background(), srcFile, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sialink2.URI() != sialink {
+		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
+	}
+
+	// Test that uploading with a cancelled context fails without contacting
+	// the portal.
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.UploadFileWithContext(ctx, srcFile, opts)
+	if !errors.Contains(err, context.Canceled) {
+		t.Fatalf("expected error %v, got %v", context.Canceled, err)
+	}
+
+	// Verify we don't have pending mocks.
+	if !gock.IsDone() {
+		t.Fatal("test finished with pending mocks")
+	}
+}
+
+// TestUploadStreaming tests that uploads are streamed to the portal and that
+// errors reading the upload data fail the upload.
+func TestUploadStreaming(t *testing.T) {
+	var buffered bool
+	var body []byte
+	httpClient := &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			// Buffered bodies have a known length and can be replayed.
+			buffered = req.ContentLength > 0 || req.GetBody != nil
+			var err error
+			body, err = ioutil.ReadAll(req.Body)
+			if err != nil {
+				return nil, err
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Header:     make(http.Header),
+				Body:       ioutil.NopCloser(strings.NewReader(`{"skylink":"` + skylink + `"}`)),
+				Request:    req,
+			}, nil
+		}),
+	}
+	opts := skynet.DefaultUploadOptions
+	opts.HTTPClient = httpClient
+
+	// Test that the body is streamed and the Content-Type is still sniffed
+	// for data without a known extension.
+
+	uploadData := skynet.UploadData{"data": strings.NewReader("<html></html>")}
+	sialink2, err := client.Upload(uploadData, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sialink2.URI() != sialink {
+		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
+	}
+	if buffered {
+		t.Fatal("expected body to be streamed")
+	}
+	if !strings.Contains(string(body), "Content-Type: text/html") {
+		t.Fatalf("expected body to contain sniffed Content-Type, got %v", string(body))
+	}
+	if !strings.Contains(string(body), "<html></html>") {
+		t.Fatalf("expected body to contain file contents, got %v", string(body))
+	}
+
+	// Test that a failing reader fails the upload.
+
+	errRead := errors.New("read failed")
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = pw.Write([]byte("partial data"))
+		_ = pw.CloseWithError(errRead)
+	}()
+	uploadData = skynet.UploadData{"data.txt": pr}
+	_, err = client.Upload(uploadData, opts)
+	if !errors.Contains(err, errRead) {
+		t.Fatalf("expected error %v, got %v", errRead, err)
+	}
+}
+
+// TestUploadInvalidSkylink tests that an invalid skylink returned by the
+// portal fails the upload.
+func TestUploadInvalidSkylink(t *testing.T) {
+	defer gock.Off()
+
+	opts := skynet.DefaultUploadOptions
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.EndpointPath).
+		Reply(200).
+		JSON(map[string]string{"skylink": "invalid"})
+
+	_, err := client.UploadFile(srcFile, opts)
+	if !errors.Contains(err, skynet.ErrMalformedSkylink) {
+		t.Fatalf("expected error %v, got %v", skynet.ErrMalformedSkylink, err)
+	}
+}
+
 // TestUploadFileWithAPIKey tests uploading a single file with authentication.
 func TestUploadFileWithAPIKey(t *testing.T) {
 	defer gock.Off() // Flush pending mocks after test execution
@@ -83,7 +204,7 @@ func TestUploadFileWithAPIKey(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if sialink2 != sialink {
+	if sialink2.URI() != sialink {
 		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
 	}
 
@@ -112,7 +233,7 @@ func TestUploadFileWithSkynetAPIKey(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if sialink2 != sialink {
+	if sialink2.URI() != sialink {
 		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
 	}
 
@@ -147,7 +268,7 @@ func TestUploadCustomUserAgent(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if sialink2 != sialink {
+	if sialink2.URI() != sialink {
 		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
 	}
 
@@ -166,7 +287,7 @@ func TestUploadCustomUserAgent(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if sialink2 != sialink {
+	if sialink2.URI() != sialink {
 		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
 	}
 
@@ -197,7 +318,7 @@ func TestUploadFileCustomName(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if sialink2 != sialink {
+	if sialink2.URI() != sialink {
 		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
 	}
 
@@ -234,7 +355,7 @@ func TestUploadFileSkykey(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if sialink2 != sialink {
+	if sialink2.URI() != sialink {
 		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
 	}
 
@@ -252,7 +373,7 @@ func TestUploadFileSkykey(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if sialink2 != sialink {
+	if sialink2.URI() != sialink {
 		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
 	}
 
@@ -304,7 +425,7 @@ func TestUploadDirectory(t *testing.T) {
 		t.Fatalf("expected %v files sent, got %v", numFilesInDir, count)
 	}
 
-	if sialink2 != sialink {
+	if sialink2.URI() != sialink {
 		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
 	}
 
@@ -319,6 +440,56 @@ func TestUploadDirectory(t *testing.T) {
 	}
 }
 
+// TestUploadDirectoryProgress tests that the progress of a directory upload is
+// reported.
+func TestUploadDirectoryProgress(t *testing.T) {
+	defer gock.Off()
+
+	opts := skynet.DefaultUploadOptions
+	gock.New(skynet.DefaultPortalURL()).
+		Post(opts.EndpointPath).
+		Reply(200).
+		JSON(map[string]string{"skylink": skylink})
+
+	var reports []skynet.UploadProgress
+	opts.Progress = func(progress skynet.UploadProgress) {
+		reports = append(reports, progress)
+	}
+	opts.ProgressInterval = time.Hour
+	_, err := client.UploadDirectory(srcDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every file should have been reported as complete, and the total size
+	// should be known up front.
+	const totalSize = 37
+	completed := make(map[string]bool)
+	for _, report := range reports {
+		if report.TotalSize != totalSize {
+			t.Fatalf("expected total size %v, got %v", totalSize, report.TotalSize)
+		}
+		if report.FileSize < 0 {
+			t.Fatalf("expected file size of %v to be known", report.Filename)
+		}
+		if report.FileBytes == report.FileSize {
+			completed[report.Filename] = true
+		}
+	}
+	if len(completed) != numFilesInDir {
+		t.Fatalf("expected %v completed files, got %v", numFilesInDir, len(completed))
+	}
+	// The interval should limit the reports to the first one and one per
+	// file.
+	if len(reports) > numFilesInDir+1 {
+		t.Fatalf("expected at most %v reports, got %v", numFilesInDir+1, len(reports))
+	}
+	last := reports[len(reports)-1]
+	if last.TotalBytes != totalSize {
+		t.Fatalf("expected %v total bytes, got %v", totalSize, last.TotalBytes)
+	}
+}
+
 // TestUploadDirectoryContentTypes tests that uploading a directory sets the
 // correct content types for subfiles.
 func TestUploadDirectoryContentTypes(t *testing.T) {
@@ -418,7 +589,7 @@ func TestUploadDirectorySkykey(t *testing.T) {
 		t.Fatalf("expected %v files sent, got %v", numFilesInDir, count)
 	}
 
-	if sialink2 != sialink {
+	if sialink2.URI() != sialink {
 		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
 	}
 
@@ -445,7 +616,7 @@ func TestUploadDirectorySkykey(t *testing.T) {
 		t.Fatalf("expected %v files sent, got %v", numFilesInDir, count)
 	}
 
-	if sialink2 != sialink {
+	if sialink2.URI() != sialink {
 		t.Fatalf("expected sialink %v, got %v", sialink, sialink2)
 	}
 
@@ -454,3 +625,81 @@ func TestUploadDirectorySkykey(t *testing.T) {
 		t.Fatal("test finished with pending mocks")
 	}
 }
+
+// TestUploadEncrypted tests uploading a file that is encrypted on the client
+// and downloading it with the same key.
+func TestUploadEncrypted(t *testing.T) {
+	fp, server, client := newFakePortal()
+	defer server.Close()
+	key, err := skynet.GenEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(srcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := skynet.DefaultUploadOptions
+	opts.EncryptionKey = &key
+	skylink, err := client.UploadFile(srcFile, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := fp.files[skylink.String()]; strings.Contains(string(stored), string(data)) {
+		t.Fatal("expected the portal to receive encrypted data")
+	}
+
+	// Download the file with and without the key.
+	downloadOpts := skynet.DefaultDownloadOptions
+	downloadOpts.EncryptionKey = &key
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	dst := filepath.Join(dir, "file1.txt")
+	err = client.DownloadFile(dst, skylink.String(), downloadOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(downloaded) != string(data) {
+		t.Fatalf("expected %q, got %q", data, downloaded)
+	}
+	body, err := client.Download(skylink.String(), skynet.DefaultDownloadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = body.Close()
+	if string(encrypted) == string(data) {
+		t.Fatal("expected encrypted data without the key")
+	}
+
+	// Downloading with another key should fail.
+	otherKey, err := skynet.GenEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloadOpts.EncryptionKey = &otherKey
+	body, err = client.Download(skylink.String(), downloadOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(body)
+	_ = body.Close()
+	if !errors.Contains(err, skynet.ErrDecryption) {
+		t.Fatalf("expected error %v, got %v", skynet.ErrDecryption, err)
+	}
+}
@@ -2,9 +2,11 @@ package skynet
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime"
 	"mime/multipart"
 	"net/http"
@@ -13,7 +15,9 @@ import (
 	"os"
 	gopath "path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"gitlab.com/NebulousLabs/errors"
 )
@@ -45,6 +49,25 @@ type (
 		SkykeyName string
 		// SkykeyID is the ID of the skykey used to encrypt the upload.
 		SkykeyID string
+		// EncryptionKey is the key used to encrypt the contents and filenames
+		// of the upload on the client, unlike skykeys which are held by the
+		// portal. The upload can only be downloaded with the same key.
+		EncryptionKey *EncryptionKey
+
+		// Progress is called with the progress of the upload, at most once
+		// per ProgressInterval and once when each file has been sent. Sizes
+		// are known for files on disk and in-memory readers. Calls are made
+		// one at a time from a separate goroutine, so the function should
+		// return quickly and hand the progress off to any UI thread.
+		Progress UploadProgressFunc
+		// ProgressInterval is the minimum time between progress reports. If
+		// zero, DefaultProgressInterval is used.
+		ProgressInterval time.Duration
+
+		// fileContentType is the content type of the uploaded files. If it
+		// is empty, the content type is inferred from the filenames and the
+		// data. Set internally.
+		fileContentType string
 	}
 
 	// UploadResponse contains the response for uploads.
@@ -65,27 +88,136 @@ var (
 		CustomDirname:                "",
 		SkykeyName:                   "",
 		SkykeyID:                     "",
+		EncryptionKey:                nil,
+		Progress:                     nil,
+		ProgressInterval:             0,
 	}
+
+	// ErrContentTooLarge is returned when computing the skylink of content
+	// that doesn't fit in a single base sector along with its metadata.
+	ErrContentTooLarge = errors.New("content doesn't fit in a single base sector")
 )
 
+// ComputeSkylink returns the skylink the given data gets when it is uploaded
+// with Upload and the same options, without contacting a portal. The data is
+// read from the readers.
+//
+// Only unencrypted content that fits in a single base sector along with its
+// metadata is supported, ErrContentTooLarge is returned for larger content.
+func ComputeSkylink(uploadData UploadData, opts UploadOptions) (Skylink, error) {
+	if opts.SkykeyName != "" || opts.SkykeyID != "" || opts.EncryptionKey != nil {
+		return Skylink{}, errors.New("the skylink of an encrypted upload can't be computed")
+	}
+	if len(uploadData) == 0 {
+		return Skylink{}, errors.New("no data to upload")
+	}
+	_, filename, err := uploadFieldAndFilename(uploadData, opts)
+	if err != nil {
+		return Skylink{}, err
+	}
+
+	// Build the metadata like a portal does for a multipart upload, with the
+	// files in the order they are uploaded in.
+	metadata := SkyfileMetadata{
+		Filename: filename,
+		Subfiles: make(map[string]SkyfileSubfileMetadata),
+		TryFiles: defaultTryFiles,
+	}
+	var content []byte
+	for _, filename := range sortedFilenames(uploadData) {
+		// Read one byte more than fits to detect content that is too large.
+		data, err := ioutil.ReadAll(io.LimitReader(uploadData[filename], int64(sectorSize-len(content)+1)))
+		if err != nil {
+			return Skylink{}, errors.AddContext(err, fmt.Sprintf("could not read data for file %v", filename))
+		}
+		if len(content)+len(data) > sectorSize {
+			return Skylink{}, ErrContentTooLarge
+		}
+		contentType := opts.fileContentType
+		if contentType == "" {
+			contentType, err = getFileContentType(filename, bytes.NewReader(data))
+		}
+		if err != nil {
+			return Skylink{}, errors.AddContext(err, fmt.Sprintf("could not get content type for file %v", filename))
+		}
+		metadata.Subfiles[filename] = SkyfileSubfileMetadata{
+			Filename:    filename,
+			ContentType: contentType,
+			Offset:      uint64(len(content)),
+			Len:         uint64(len(data)),
+		}
+		content = append(content, data...)
+		// A single file gives its name to the skyfile.
+		if metadata.Filename == "" {
+			metadata.Filename = filename
+		}
+	}
+	metadata.Length = uint64(len(content))
+
+	baseSector, err := buildBaseSector(metadata, content)
+	if err != nil {
+		return Skylink{}, err
+	}
+	sector := make([]byte, sectorSize)
+	copy(sector, baseSector)
+	return NewSkylinkV1(merkleRoot(sector), 0, uint64(len(baseSector)))
+}
+
+// ComputeSkylinkFile returns the skylink the file gets when it is uploaded with
+// UploadFile and the same options, without contacting a portal. See
+// ComputeSkylink for the supported content.
+func ComputeSkylinkFile(path string, opts UploadOptions) (skylink Skylink, err error) {
+	uploadData, file, err := openUploadFile(path, opts)
+	if err != nil {
+		return Skylink{}, err
+	}
+	defer func() {
+		err = errors.Extend(err, file.Close())
+	}()
+
+	return ComputeSkylink(uploadData, opts)
+}
+
+// ComputeSkylinkDirectory returns the skylink the directory gets when it is
+// uploaded with UploadDirectory and the same options, without contacting a
+// portal. See ComputeSkylink for the supported content.
+func ComputeSkylinkDirectory(path string, opts UploadOptions) (skylink Skylink, err error) {
+	uploadData, files, err := openUploadDirectory(path, &opts)
+	if err != nil {
+		return Skylink{}, err
+	}
+	defer func() {
+		err = errors.Extend(err, closeFiles(files))
+	}()
+
+	return ComputeSkylink(uploadData, opts)
+}
+
 // Upload uploads the given generic data and returns the skylink.
-func (sc *SkynetClient) Upload(uploadData UploadData, opts UploadOptions) (skylink string, err error) {
-	// prepare formdata
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+func (sc *SkynetClient) Upload(uploadData UploadData, opts UploadOptions) (skylink Skylink, err error) {
+	return sc.UploadWithContext(context.Background(), uploadData, opts)
+}
 
-	var fieldname string
-	var filename string
-	// Upload as a directory if the dirname is set, even if there is only 1
-	// file.
-	if len(uploadData) == 1 && opts.CustomDirname == "" {
-		fieldname = opts.PortalFileFieldName
-	} else {
-		if opts.CustomDirname == "" {
-			return "", errors.New("CustomDirname must be set when uploading multiple files")
+// UploadWithContext uploads the given generic data and returns the skylink.
+// The upload is aborted when the context is cancelled.
+func (sc *SkynetClient) UploadWithContext(ctx context.Context, uploadData UploadData, opts UploadOptions) (skylink Skylink, err error) {
+	if opts.EncryptionKey != nil {
+		uploadData, err = encryptUploadData(*opts.EncryptionKey, uploadData)
+		if err != nil {
+			return Skylink{}, errors.AddContext(err, "could not encrypt upload")
+		}
+		if opts.CustomDirname != "" {
+			opts.CustomDirname, err = EncryptFilename(*opts.EncryptionKey, opts.CustomDirname)
+			if err != nil {
+				return Skylink{}, errors.AddContext(err, "could not encrypt directory name")
+			}
 		}
-		fieldname = opts.PortalDirectoryFileFieldName
-		filename = opts.CustomDirname
+	}
+
+	// prepare formdata
+	fieldname, filename, err := uploadFieldAndFilename(uploadData, opts)
+	if err != nil {
+		return Skylink{}, err
 	}
 
 	values := url.Values{}
@@ -101,70 +233,113 @@ func (sc *SkynetClient) Upload(uploadData UploadData, opts UploadOptions) (skyli
 		values.Set("skykeyid", opts.SkykeyID)
 	}
 
-	for filename, data := range uploadData {
-		// We may need to do a read to determine the Content-Type. Tee the read
-		// into a buffer so we can read again.
-		var buf bytes.Buffer
-		tee := io.TeeReader(data, &buf)
-		// Create the form file, inferring the Content-Type.
-		part, err := createFormFileContentType(writer, fieldname, filename, tee)
-		if err != nil {
-			return "", errors.AddContext(err, fmt.Sprintf("could not create form file for file %v", filename))
-		}
-		// Copy from the buffer and then the rest of the data that hasn't been
-		// read.
-		_, err = io.Copy(part, &buf)
-		_, err2 := io.Copy(part, data)
-		if errors.Compose(err, err2) != nil {
-			return "", errors.AddContext(err, fmt.Sprintf("could not copy data for file %v", filename))
-		}
-	}
-
-	err = writer.Close()
-	if err != nil {
-		return "", errors.AddContext(err, "could not close writer")
-	}
+	// Stream the formdata into the request body while the request is in
+	// flight, so that the upload doesn't have to be buffered in memory.
+	bodyReader, bodyWriter := io.Pipe()
+	writer := multipart.NewWriter(bodyWriter)
 	opts.customContentType = writer.FormDataContentType()
+	writeErrChan := make(chan error, 1)
+	go func() {
+		err := writeMultipartBody(ctx, writer, fieldname, uploadData, opts)
+		// Closing the pipe with a nil error is equivalent to Close.
+		_ = bodyWriter.CloseWithError(err)
+		writeErrChan <- err
+	}()
 
 	resp, err := sc.executeRequest(
 		requestOptions{
 			Options: opts.Options,
+			ctx:     ctx,
 			method:  "POST",
-			reqBody: body,
+			reqBody: bodyReader,
 			query:   values,
 		},
 	)
+	// Unblock the writer in case the request finished before the whole body
+	// was read, and wait for it to exit.
+	_ = bodyReader.Close()
+	writeErr := <-writeErrChan
+	if errors.Contains(writeErr, io.ErrClosedPipe) {
+		// The request was finished before the body was written, any error
+		// was returned by the request.
+		writeErr = nil
+	}
 	if err != nil {
-		return "", errors.AddContext(err, "could not execute request")
+		return Skylink{}, errors.AddContext(errors.Compose(writeErr, err), "could not execute request")
+	}
+	if writeErr != nil {
+		// Don't trust a response to an incomplete upload.
+		_ = resp.Body.Close()
+		return Skylink{}, errors.AddContext(writeErr, "could not write request body")
 	}
 
 	respBody, err := parseResponseBody(resp)
 	if err != nil {
-		return "", errors.AddContext(err, "could not parse response body")
+		return Skylink{}, errors.AddContext(err, "could not parse response body")
 	}
 
 	var apiResponse UploadResponse
 	err = json.Unmarshal(respBody.Bytes(), &apiResponse)
 	if err != nil {
-		return "", errors.AddContext(err, "could not unmarshal response JSON")
+		return Skylink{}, errors.AddContext(err, "could not unmarshal response JSON")
 	}
 
-	return fmt.Sprintf("%s%s", URISkynetPrefix, apiResponse.Skylink), nil
+	skylink, err = ParseSkylink(apiResponse.Skylink)
+	if err != nil {
+		return Skylink{}, errors.AddContext(err, "portal returned an invalid skylink")
+	}
+	return skylink, nil
 }
 
 // UploadFile uploads a file to Skynet and returns the skylink.
-func (sc *SkynetClient) UploadFile(path string, opts UploadOptions) (skylink string, err error) {
-	path = gopath.Clean(path)
+func (sc *SkynetClient) UploadFile(path string, opts UploadOptions) (skylink Skylink, err error) {
+	return sc.UploadFileWithContext(context.Background(), path, opts)
+}
 
-	// Open the file.
-	file, err := os.Open(gopath.Clean(path)) // Clean again to prevent lint error.
+// UploadFileWithContext uploads a file to Skynet and returns the skylink. The
+// upload is aborted when the context is cancelled.
+func (sc *SkynetClient) UploadFileWithContext(ctx context.Context, path string, opts UploadOptions) (skylink Skylink, err error) {
+	uploadData, file, err := openUploadFile(path, opts)
 	if err != nil {
-		return "", errors.AddContext(err, fmt.Sprintf("could not open file %v", path))
+		return Skylink{}, err
 	}
 	defer func() {
 		err = errors.Extend(err, file.Close())
 	}()
 
+	return sc.UploadWithContext(ctx, uploadData, opts)
+}
+
+// UploadDirectory uploads a local directory to Skynet and returns the skylink.
+func (sc *SkynetClient) UploadDirectory(path string, opts UploadOptions) (skylink Skylink, err error) {
+	return sc.UploadDirectoryWithContext(context.Background(), path, opts)
+}
+
+// UploadDirectoryWithContext uploads a local directory to Skynet and returns
+// the skylink. The upload is aborted when the context is cancelled.
+func (sc *SkynetClient) UploadDirectoryWithContext(ctx context.Context, path string, opts UploadOptions) (skylink Skylink, err error) {
+	uploadData, files, err := openUploadDirectory(path, &opts)
+	if err != nil {
+		return Skylink{}, err
+	}
+	defer func() {
+		err = errors.Extend(err, closeFiles(files))
+	}()
+
+	return sc.UploadWithContext(ctx, uploadData, opts)
+}
+
+// openUploadFile opens the file at the given path for uploading. The caller
+// has to close the returned file.
+func openUploadFile(path string, opts UploadOptions) (UploadData, *os.File, error) {
+	path = gopath.Clean(path)
+
+	// Open the file.
+	file, err := os.Open(gopath.Clean(path)) // Clean again to prevent lint error.
+	if err != nil {
+		return nil, nil, errors.AddContext(err, fmt.Sprintf("could not open file %v", path))
+	}
+
 	// Set filename.
 	filename := filepath.Base(path)
 	if opts.CustomFilename != "" {
@@ -173,27 +348,28 @@ func (sc *SkynetClient) UploadFile(path string, opts UploadOptions) (skylink str
 
 	uploadData := make(UploadData)
 	uploadData[filename] = file
-
-	return sc.Upload(uploadData, opts)
+	return uploadData, file, nil
 }
 
-// UploadDirectory uploads a local directory to Skynet and returns the skylink.
-func (sc *SkynetClient) UploadDirectory(path string, opts UploadOptions) (skylink string, err error) {
+// openUploadDirectory opens the files in the directory at the given path for
+// uploading and sets the directory name in the options if it is empty. The
+// caller has to close the returned files.
+func openUploadDirectory(path string, opts *UploadOptions) (_ UploadData, files []*os.File, err error) {
 	path = gopath.Clean(path)
 
 	// Verify the given path is a directory.
 	info, err := os.Stat(path)
 	if err != nil {
-		return "", errors.AddContext(err, "error retrieving path info")
+		return nil, nil, errors.AddContext(err, "error retrieving path info")
 	}
 	if !info.IsDir() {
-		return "", fmt.Errorf("given path %v is not a directory", path)
+		return nil, nil, fmt.Errorf("given path %v is not a directory", path)
 	}
 
 	// Find all files in the given directory.
-	files, err := walkDirectory(path)
+	paths, err := walkDirectory(path)
 	if err != nil {
-		return "", errors.AddContext(err, "error walking directory")
+		return nil, nil, errors.AddContext(err, "error walking directory")
 	}
 
 	// Set DirName.
@@ -207,23 +383,119 @@ func (sc *SkynetClient) UploadDirectory(path string, opts UploadOptions) (skylin
 	if basepath != "/" {
 		basepath += "/"
 	}
-	for _, filepath := range files {
+	for _, filepath := range paths {
 		file, err := os.Open(gopath.Clean(filepath)) // Clean again to prevent lint error.
 		if err != nil {
-			return "", errors.AddContext(err, "error opening file")
+			return nil, nil, errors.Compose(errors.AddContext(err, "error opening file"), closeFiles(files))
 		}
+		files = append(files, file)
 		// Remove the base path before uploading. Any ending '/' was removed
 		// from `path` with `Clean`.
 		filepath = strings.TrimPrefix(filepath, basepath)
 		uploadData[filepath] = file
 	}
+	return uploadData, files, nil
+}
 
-	return sc.Upload(uploadData, opts)
+// closeFiles closes the given files.
+func closeFiles(files []*os.File) error {
+	var errs []error
+	for _, file := range files {
+		errs = append(errs, file.Close())
+	}
+	return errors.Compose(errs...)
+}
+
+// uploadFieldAndFilename returns the form field name of the files of an upload
+// and the filename of the skyfile. The filename is empty for a single file, as
+// the portal then uses the name of the file.
+func uploadFieldAndFilename(uploadData UploadData, opts UploadOptions) (fieldname, filename string, err error) {
+	// Upload as a directory if the dirname is set, even if there is only 1
+	// file.
+	if len(uploadData) == 1 && opts.CustomDirname == "" {
+		return opts.PortalFileFieldName, "", nil
+	}
+	if opts.CustomDirname == "" {
+		return "", "", errors.New("CustomDirname must be set when uploading multiple files")
+	}
+	return opts.PortalDirectoryFileFieldName, opts.CustomDirname, nil
+}
+
+// sortedFilenames returns the filenames of the upload data in the order they
+// are uploaded in. The order determines the offsets of the files within the
+// skyfile, so it has to be deterministic for the skylink to be.
+func sortedFilenames(uploadData UploadData) []string {
+	filenames := make([]string, 0, len(uploadData))
+	for filename := range uploadData {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	return filenames
+}
+
+// writeMultipartBody writes the given upload data as multipart formdata to the
+// writer and closes it, reporting the progress if requested in the options.
+func writeMultipartBody(ctx context.Context, writer *multipart.Writer, fieldname string, uploadData UploadData, opts UploadOptions) error {
+	var progress UploadProgress
+	var throttle *progressThrottle
+	if opts.Progress != nil {
+		progress.TotalSize = uploadDataSize(uploadData)
+		throttle = newProgressThrottle(opts.ProgressInterval)
+	}
+
+	for _, filename := range sortedFilenames(uploadData) {
+		data := uploadData[filename]
+		// Stop writing the body if the upload was cancelled.
+		if err := ctx.Err(); err != nil {
+			return errors.AddContext(err, "upload cancelled")
+		}
+		// Get the size before reading from the data.
+		if opts.Progress != nil {
+			progress.Filename = filename
+			progress.FileBytes = 0
+			progress.FileSize = readerSize(data)
+		}
+		// We may need to do a read to determine the Content-Type. Tee the read
+		// into a buffer so we can read again.
+		var buf bytes.Buffer
+		tee := io.TeeReader(data, &buf)
+		// Create the form file, inferring the Content-Type.
+		part, err := createFormFileContentType(writer, fieldname, filename, opts.fileContentType, tee)
+		if err != nil {
+			return errors.AddContext(err, fmt.Sprintf("could not create form file for file %v", filename))
+		}
+		if opts.Progress != nil {
+			part = &uploadProgressWriter{
+				w:        part,
+				fn:       opts.Progress,
+				progress: &progress,
+				throttle: throttle,
+			}
+		}
+		// Copy from the buffer and then the rest of the data that hasn't been
+		// read.
+		_, err = io.Copy(part, &buf)
+		_, err2 := io.Copy(part, data)
+		if err := errors.Compose(err, err2); err != nil {
+			return errors.AddContext(err, fmt.Sprintf("could not copy data for file %v", filename))
+		}
+		// Always report when a file has been sent.
+		if opts.Progress != nil && throttle.ready(true) {
+			opts.Progress(progress)
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		return errors.AddContext(err, "could not close writer")
+	}
+	return nil
 }
 
 // createFormFileContentType is based on multipart.Writer.CreateFormFile, except
-// it properly sets the content types.
-func createFormFileContentType(w *multipart.Writer, fieldname, filename string, file io.Reader) (io.Writer, error) {
+// it properly sets the content types. The content type is inferred from the
+// filename and file if it is empty.
+func createFormFileContentType(w *multipart.Writer, fieldname, filename, contentType string, file io.Reader) (io.Writer, error) {
 	escapeQuotes := func(s string) string {
 		var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
 		return quoteEscaper.Replace(s)
@@ -233,9 +505,12 @@ func createFormFileContentType(w *multipart.Writer, fieldname, filename string,
 	h.Set("Content-Disposition",
 		fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
 			escapeQuotes(fieldname), escapeQuotes(filename)))
-	contentType, err := getFileContentType(filename, file)
-	if err != nil {
-		return nil, err
+	if contentType == "" {
+		var err error
+		contentType, err = getFileContentType(filename, file)
+		if err != nil {
+			return nil, err
+		}
 	}
 	h.Set("Content-Type", contentType)
 	return w.CreatePart(h)
@@ -248,17 +523,19 @@ func getFileContentType(filename string, file io.Reader) (string, error) {
 		return contentType, nil
 	}
 
-	// Only the first 512 bytes are used to sniff the content type.
+	// Only the first 512 bytes are used to sniff the content type. Streamed
+	// data may return fewer bytes per read, so read until the buffer is full
+	// or the data is exhausted.
 	buffer := make([]byte, 512)
 
-	_, err := file.Read(buffer)
-	if err != nil {
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		return "", err
 	}
 
 	// Always returns a valid content-type by returning
 	// "application/octet-stream" if no others seemed to match.
-	contentType = http.DetectContentType(buffer)
+	contentType = http.DetectContentType(buffer[:n])
 
 	return contentType, nil
 }
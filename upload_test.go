@@ -0,0 +1,123 @@
+package skynet
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestComputeSkylink tests computing the skylinks of uploads against base
+// sectors written out by hand.
+func TestComputeSkylink(t *testing.T) {
+	tests := []struct {
+		name       string
+		uploadData map[string]string
+		opts       UploadOptions
+		metadata   string
+		content    string
+	}{
+		{
+			"file",
+			map[string]string{"file1.txt": "test\n"},
+			DefaultUploadOptions,
+			`{"filename":"file1.txt","length":5,"subfiles":{"file1.txt":{"filename":"file1.txt","contenttype":"text/plain; charset=utf-8","len":5}},"tryfiles":["index.html"]}`,
+			"test\n",
+		},
+		{
+			"empty file",
+			map[string]string{"empty": ""},
+			DefaultUploadOptions,
+			`{"filename":"empty","length":0,"subfiles":{"empty":{"filename":"empty","contenttype":"text/plain; charset=utf-8"}},"tryfiles":["index.html"]}`,
+			"",
+		},
+		{
+			"directory",
+			map[string]string{
+				"index.html": "<html>hello</html>",
+				"dir/file":   "file",
+			},
+			UploadOptions{
+				PortalDirectoryFileFieldName: "files[]",
+				CustomDirname:                "site",
+			},
+			`{"filename":"site","length":22,"subfiles":{"dir/file":{"filename":"dir/file","contenttype":"text/plain; charset=utf-8","len":4},"index.html":{"filename":"index.html","contenttype":"text/html; charset=utf-8","offset":4,"len":18}},"tryfiles":["index.html"]}`,
+			"file<html>hello</html>",
+		},
+	}
+	for _, test := range tests {
+		skylink, err := ComputeSkylink(makeUploadData(test.uploadData), test.opts)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		expected := baseSectorSkylink(t, test.metadata, test.content)
+		if skylink != expected {
+			t.Fatalf("%v: expected skylink %v, got %v", test.name, expected, skylink)
+		}
+	}
+
+	// Test files and directories on disk.
+	skylink, err := ComputeSkylinkFile("testdata/file1.txt", DefaultUploadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := baseSectorSkylink(t, tests[0].metadata, tests[0].content)
+	if skylink != expected {
+		t.Fatalf("expected skylink %v, got %v", expected, skylink)
+	}
+	skylink, err = ComputeSkylinkDirectory("testdata/dir1", DefaultUploadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = baseSectorSkylink(t, `{"filename":"dir1","length":4,"subfiles":{"file3.txt":{"filename":"file3.txt","contenttype":"text/plain; charset=utf-8","len":4}},"tryfiles":["index.html"]}`, "bar\n")
+	if skylink != expected {
+		t.Fatalf("expected skylink %v, got %v", expected, skylink)
+	}
+
+	// Test content that doesn't fit in the base sector.
+	large := map[string]string{"large": strings.Repeat("a", sectorSize-skyfileLayoutSize)}
+	_, err = ComputeSkylink(makeUploadData(large), DefaultUploadOptions)
+	if !errors.Contains(err, ErrContentTooLarge) {
+		t.Fatalf("expected error %v, got %v", ErrContentTooLarge, err)
+	}
+
+	// Test encrypted uploads.
+	opts := DefaultUploadOptions
+	opts.SkykeyName = "skykey"
+	if _, err := ComputeSkylink(makeUploadData(tests[0].uploadData), opts); err == nil {
+		t.Fatal("expected encrypted upload to fail")
+	}
+}
+
+// makeUploadData returns upload data with the given contents.
+func makeUploadData(contents map[string]string) UploadData {
+	uploadData := make(UploadData)
+	for filename, content := range contents {
+		uploadData[filename] = strings.NewReader(content)
+	}
+	return uploadData
+}
+
+// baseSectorSkylink returns the skylink of an unencrypted skyfile with the
+// given metadata and content in its base sector. The layout is written out
+// byte by byte, following SkyfileLayout.Encode of skyd v1.5.9, instead of
+// being encoded by this package.
+func baseSectorSkylink(t *testing.T, metadata, content string) Skylink {
+	t.Helper()
+	sector := make([]byte, sectorSize)
+	sector[0] = 1 // version
+	binary.LittleEndian.PutUint64(sector[1:], uint64(len(content)))
+	binary.LittleEndian.PutUint64(sector[9:], uint64(len(metadata)))
+	// The fanout size, the fanout data and parity pieces and the key data
+	// are zero, and the cipher type is plain.
+	sector[34] = 1
+	n := 99
+	n += copy(sector[n:], metadata)
+	n += copy(sector[n:], content)
+	skylink, err := NewSkylinkV1(merkleRoot(sector), 0, uint64(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return skylink
+}
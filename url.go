@@ -0,0 +1,151 @@
+package skynet
+
+import (
+	"net/url"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// ExtractedSkylink contains a skylink extracted from a URL or string,
+	// along with the path and query that followed it.
+	ExtractedSkylink struct {
+		// Skylink is the extracted skylink.
+		Skylink Skylink
+		// Path is the unescaped path of a file within the skyfile, starting
+		// with a '/', or empty if there is no path.
+		Path string
+		// Query contains the query parameters.
+		Query url.Values
+	}
+
+	// SkylinkURLOptions contains the options used for making skylink URLs.
+	SkylinkURLOptions struct {
+		// Subdomain makes a URL with the base32 encoded skylink as a subdomain
+		// of the portal, e.g. https://<base32 skylink>.siasky.net/path,
+		// instead of https://siasky.net/<skylink>/path.
+		Subdomain bool
+		// Path is the path of a file within the skyfile.
+		Path string
+		// Query contains query parameters added to the URL.
+		Query url.Values
+	}
+)
+
+const (
+	// skylinkAPIPath is the path prefix of the skylink API endpoint.
+	skylinkAPIPath = "skynet/skylink/"
+)
+
+var (
+	// DefaultSkylinkURLOptions contains the default skylink URL options.
+	DefaultSkylinkURLOptions = SkylinkURLOptions{
+		Subdomain: false,
+		Path:      "",
+		Query:     nil,
+	}
+)
+
+// ExtractSkylink extracts a skylink from any of the forms it is commonly shared
+// in:
+//
+//   - a base64 or base32 encoded skylink, optionally with the sia:// prefix
+//   - a portal URL such as https://siasky.net/<skylink>/dir/file?query, with
+//     or without the scheme
+//   - a subdomain URL such as https://<base32 skylink>.siasky.net/dir/file
+//   - an API path such as /skynet/skylink/<skylink>/dir/file
+//
+// The skylink may be followed by a path and query in any of these forms.
+func ExtractSkylink(s string) (ExtractedSkylink, error) {
+	s = strings.TrimSpace(s)
+	isURL := strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+	if strings.HasPrefix(s, URISkynetPrefix) {
+		s = strings.TrimPrefix(s, URISkynetPrefix)
+	} else if strings.HasPrefix(s, "sia:") {
+		s = strings.TrimPrefix(s, "sia:")
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return ExtractedSkylink{}, errors.Compose(ErrMalformedSkylink, errors.AddContext(err, "could not parse URL"))
+	}
+	query := u.Query()
+
+	// Check for a base32 encoded skylink in the subdomain.
+	if isURL {
+		labels := strings.Split(u.Hostname(), ".")
+		if len(labels[0]) == base32EncodedSkylinkSize {
+			if sl, err := ParseSkylink(labels[0]); err == nil {
+				return ExtractedSkylink{Skylink: sl, Path: cleanSkylinkPath(u.Path), Query: query}, nil
+			}
+		}
+	}
+
+	// Otherwise the skylink is the first element of the path, after any API
+	// path.
+	path := strings.TrimPrefix(u.Path, "/")
+	path = strings.TrimPrefix(path, skylinkAPIPath)
+	parts := strings.SplitN(path, "/", 2)
+	sl, err := ParseSkylink(parts[0])
+	if err != nil && !isURL && strings.Contains(parts[0], ".") {
+		// The string might be a URL without a scheme.
+		return ExtractSkylink("https://" + s)
+	}
+	if err != nil {
+		return ExtractedSkylink{}, errors.AddContext(err, "could not find a skylink")
+	}
+	var rest string
+	if len(parts) == 2 {
+		rest = parts[1]
+	}
+	return ExtractedSkylink{Skylink: sl, Path: cleanSkylinkPath(rest), Query: query}, nil
+}
+
+// SkylinkURL returns the URL of the given base64 or base32 encoded skylink on
+// the client's portal.
+func (sc *SkynetClient) SkylinkURL(skylink string, opts SkylinkURLOptions) (string, error) {
+	sl, err := ParseSkylink(skylink)
+	if err != nil {
+		return "", errors.AddContext(err, "could not parse skylink")
+	}
+	u, err := url.Parse(sc.PortalURL)
+	if err != nil {
+		return "", errors.AddContext(err, "could not parse portal URL")
+	}
+	if u.Host == "" {
+		return "", errors.New("portal URL is missing a host")
+	}
+
+	// Subdomains are case-insensitive, so only the base32 encoding can be
+	// used for them.
+	basePath := strings.TrimRight(u.Path, "/")
+	if opts.Subdomain {
+		u.Host = sl.Base32EncodedString() + "." + u.Host
+	} else {
+		basePath += "/" + sl.String()
+	}
+	u.Path = basePath + "/" + strings.TrimLeft(opts.Path, "/")
+	if opts.Path == "" && !opts.Subdomain {
+		u.Path = basePath
+	}
+	u.RawPath = ""
+	u.RawQuery = opts.Query.Encode()
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// requestPath returns the escaped path of the skylink and file path, for use in
+// requests to a portal.
+func (es ExtractedSkylink) requestPath() string {
+	return es.Skylink.String() + (&url.URL{Path: es.Path}).EscapedPath()
+}
+
+// cleanSkylinkPath returns the path with a single leading '/', or an empty
+// string for an empty path.
+func cleanSkylinkPath(path string) string {
+	path = strings.TrimLeft(path, "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
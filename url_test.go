@@ -0,0 +1,146 @@
+package skynet
+
+import (
+	"net/url"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestSkylinkURL tests making path-style and subdomain-style skylink URLs.
+func TestSkylinkURL(t *testing.T) {
+	const skylink = "XABvi7JtJbQSMAcDwnUnmp2FKDPjg8_tTTFP4BwMSxVdEg"
+	sl, err := ParseSkylink(skylink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base32 := sl.Base32EncodedString()
+
+	query := url.Values{}
+	query.Set("format", "zip")
+	query.Set("name", "a&b")
+	tests := []struct {
+		portalURL string
+		skylink   string
+		opts      SkylinkURLOptions
+		out       string
+	}{
+		{
+			"https://siasky.net", skylink,
+			DefaultSkylinkURLOptions,
+			"https://siasky.net/" + skylink,
+		},
+		{
+			"https://siasky.net/", URISkynetPrefix + skylink,
+			SkylinkURLOptions{Path: "dir/file.txt", Query: query},
+			"https://siasky.net/" + skylink + "/dir/file.txt?format=zip&name=a%26b",
+		},
+		{
+			"https://siasky.net/test", base32,
+			SkylinkURLOptions{Path: "/my file?.txt"},
+			"https://siasky.net/test/" + skylink + "/my%20file%3F.txt",
+		},
+		{
+			"https://siasky.net", skylink,
+			SkylinkURLOptions{Subdomain: true},
+			"https://" + base32 + ".siasky.net/",
+		},
+		{
+			"https://siasky.net:8080", skylink,
+			SkylinkURLOptions{Subdomain: true, Path: "dir/index.html", Query: query},
+			"https://" + base32 + ".siasky.net:8080/dir/index.html?format=zip&name=a%26b",
+		},
+	}
+
+	for _, test := range tests {
+		client := NewCustom(test.portalURL, Options{})
+		out, err := client.SkylinkURL(test.skylink, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != test.out {
+			t.Fatalf("expected %v, got %v", test.out, out)
+		}
+	}
+
+	// An invalid skylink should fail.
+	client := NewCustom("https://siasky.net", Options{})
+	_, err = client.SkylinkURL("invalid", DefaultSkylinkURLOptions)
+	if err == nil {
+		t.Fatal("expected invalid skylink to fail")
+	}
+}
+
+// TestExtractSkylink tests extracting skylinks from URLs and strings.
+func TestExtractSkylink(t *testing.T) {
+	const skylink = "XABvi7JtJbQSMAcDwnUnmp2FKDPjg8_tTTFP4BwMSxVdEg"
+	sl, err := ParseSkylink(skylink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base32 := sl.Base32EncodedString()
+
+	tests := []struct {
+		in    string
+		path  string
+		query string
+	}{
+		{skylink, "", ""},
+		{base32, "", ""},
+		{" " + skylink + "\n", "", ""},
+		{URISkynetPrefix + skylink, "", ""},
+		{"sia:" + skylink, "", ""},
+		{URISkynetPrefix + skylink + "/dir/file.txt", "/dir/file.txt", ""},
+		{skylink + "/dir/?format=zip", "/dir/", "format=zip"},
+		{"https://siasky.net/" + skylink, "", ""},
+		{"https://siasky.net/" + skylink + "/", "", ""},
+		{"https://siasky.net/" + skylink + "/dir/my%20file.txt?a=b#fragment", "/dir/my file.txt", "a=b"},
+		{"http://localhost:9980/" + base32 + "/index.html", "/index.html", ""},
+		{"siasky.net/" + skylink + "/index.html", "/index.html", ""},
+		{"https://" + base32 + ".siasky.net", "", ""},
+		{"https://" + base32 + ".siasky.net/dir/index.html?a=b", "/dir/index.html", "a=b"},
+		{"/skynet/skylink/" + skylink + "/dir", "/dir", ""},
+		{"https://siasky.net/skynet/skylink/" + skylink, "", ""},
+	}
+	for _, test := range tests {
+		extracted, err := ExtractSkylink(test.in)
+		if err != nil {
+			t.Fatalf("%q: %v", test.in, err)
+		}
+		if extracted.Skylink != sl {
+			t.Fatalf("%q: expected skylink %v, got %v", test.in, sl, extracted.Skylink)
+		}
+		if extracted.Path != test.path {
+			t.Fatalf("%q: expected path %q, got %q", test.in, test.path, extracted.Path)
+		}
+		if query := extracted.Query.Encode(); query != test.query {
+			t.Fatalf("%q: expected query %q, got %q", test.in, test.query, query)
+		}
+	}
+
+	// Test strings without a valid skylink.
+	invalid := []string{
+		"",
+		"sia://",
+		"https://siasky.net",
+		"https://siasky.net/file.txt",
+		"https://siasky.net/" + skylink[1:],
+		"siasky.net/dir/" + skylink,
+		skylink[1:] + ".txt",
+	}
+	for _, in := range invalid {
+		_, err := ExtractSkylink(in)
+		if !errors.Contains(err, ErrMalformedSkylink) {
+			t.Fatalf("%q: expected error %v, got %v", in, ErrMalformedSkylink, err)
+		}
+	}
+
+	// The request path should be escaped.
+	extracted, err := ExtractSkylink(skylink + "/dir/my%20file%3F.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := extracted.requestPath(); path != skylink+"/dir/my%20file%3F.txt" {
+		t.Fatalf("unexpected request path %v", path)
+	}
+}
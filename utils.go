@@ -35,6 +35,16 @@ type (
 		// CustomUserAgent is the custom user agent to use.
 		CustomUserAgent string
 
+		// HTTPClient is the HTTP client used to make requests. Set it to
+		// configure timeouts, proxies, TLS settings or a custom
+		// http.RoundTripper. Registry subscriptions only use the proxy and
+		// TLS settings of an *http.Transport. If nil, http.DefaultClient is
+		// used.
+		HTTPClient *http.Client
+		// RetryPolicy configures retries of requests that failed with a
+		// transient error. Retries are disabled by default.
+		RetryPolicy RetryPolicy
+
 		// customContentType is the custom content type to use. Set internally.
 		customContentType string
 	}
@@ -85,27 +95,44 @@ func ensurePrefix(str, prefix string) string {
 	return prefix + str
 }
 
-// makeResponseError makes an error given an error response.
+// httpClient returns the HTTP client to use for requests.
+func (opts Options) httpClient() *http.Client {
+	if opts.HTTPClient != nil {
+		return opts.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// makeResponseError makes an error given an error response. The returned error
+// contains a ResponseError and ErrResponseError.
 func makeResponseError(resp *http.Response) error {
+	respErr := &ResponseError{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+	}
+	if resp.Request != nil {
+		respErr.Method = resp.Request.Method
+		respErr.URL = redactURL(resp.Request.URL)
+	}
+
 	body := &bytes.Buffer{}
 	_, err := body.ReadFrom(resp.Body)
 	if err != nil {
-		return errors.AddContext(err, "could not read from response body")
+		return errors.Compose(errors.Extend(ErrResponseError, respErr), errors.AddContext(err, "could not read from response body"))
 	}
 	err = resp.Body.Close()
 	if err != nil {
-		return errors.AddContext(err, "could not close response body")
+		return errors.Compose(errors.Extend(ErrResponseError, respErr), errors.AddContext(err, "could not close response body"))
 	}
 
 	var apiResponse ErrorResponse
-	message := string(body.Bytes())
+	respErr.Message = string(body.Bytes())
 	err = json.Unmarshal(body.Bytes(), &apiResponse)
 	if err == nil {
-		message = apiResponse.Message
+		respErr.Message = apiResponse.Message
 	}
 
-	context := fmt.Sprintf("%v response from %v: %v", resp.StatusCode, resp.Request.Method, message)
-	return errors.AddContext(ErrResponseError, context)
+	return errors.Extend(ErrResponseError, respErr)
 }
 
 // makeURL makes a URL from the given parts.